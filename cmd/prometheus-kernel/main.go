@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/xperimental/ipromnb/kernel"
@@ -9,27 +11,55 @@ import (
 )
 
 var (
-	configFile string
-	serverURL  string
+	configFile  string
+	serverURL   string
+	historyFile string
+	transport   string
+	ip          string
 )
 
 var log = logrus.New()
 
 func main() {
-	flag.StringVar(&configFile, "connection-file", "", "Path to connection file.")
+	flag.StringVar(&configFile, "connection-file", "", "Path to connection file. If empty, the kernel writes its own connection file.")
 	flag.StringVar(&serverURL, "server-url", "", "Default Prometheus server.")
+	flag.StringVar(&historyFile, "history-file", kernel.DefaultHistoryFile(), "File containing the history of executed cells. Empty to disable.")
+	flag.StringVar(&transport, "transport", "tcp", "Transport used when writing a connection file (tcp or ipc).")
+	flag.StringVar(&ip, "ip", "", "IP (or socket file prefix for ipc) used when writing a connection file. Defaults to 127.0.0.1 or a prefix in the Jupyter runtime directory.")
 	flag.Parse()
 
+	// The kernel runs in a separate function, so that the connection file is also removed on errors.
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
 	if configFile == "" {
-		log.Fatal("Need to provide a connection file.")
+		// Standalone mode, clients can attach using the connection file.
+		configFile = scaffold.DefaultConnectionFile()
+		if err := scaffold.WriteConnectionFile(configFile, transport, ip); err != nil {
+			return fmt.Errorf("Error writing connection file: %s", err)
+		}
+		defer os.Remove(configFile)
+
+		fmt.Printf("To connect a client to this kernel, use:\n    jupyter console --existing %s\n", configFile)
 	}
 
 	kernel := kernel.New(serverURL)
+	if historyFile != "" {
+		if err := kernel.OpenHistory(historyFile); err != nil {
+			log.Errorf("Error opening history: %s", err)
+		}
+	}
 
 	server, err := scaffold.NewServer(configFile, kernel)
 	if err != nil {
-		log.Fatalf("Error creating server: %s", err)
+		return fmt.Errorf("Error creating server: %s", err)
 	}
 
-	server.Loop()
+	if err := server.Loop(); err != nil {
+		return fmt.Errorf("Error shutting down: %s", err)
+	}
+	return nil
 }
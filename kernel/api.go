@@ -0,0 +1,70 @@
+package kernel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+func (k *Kernel) getClient() (api.Client, error) {
+	if k.Options.Server == "" {
+		return nil, fmt.Errorf("no server set. set one using @server=<url>")
+	}
+
+	var roundTripper http.RoundTripper
+	if k.Options.Auth != nil {
+		roundTripper = &authRoundTripper{
+			credentials: k.Options.Auth,
+			next:        api.DefaultRoundTripper,
+		}
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address:      k.Options.Server,
+		RoundTripper: roundTripper,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %s", err)
+	}
+
+	return client, nil
+}
+
+// apiResponse is the envelope of all responses of the Prometheus HTTP API.
+type apiResponse struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+	Error  string          `json:"error"`
+}
+
+// apiGet calls an endpoint of the Prometheus HTTP API, which is not supported by the client library.
+// The data contained in the response is decoded into result.
+func apiGet(ctx context.Context, client api.Client, endpoint string, params url.Values, result interface{}) error {
+	u := client.URL(endpoint, nil)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err)
+	}
+
+	_, body, err := client.Do(ctx, req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %s", endpoint, err)
+	}
+
+	var response apiResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse response of %s: %s", endpoint, err)
+	}
+
+	if response.Status != "success" {
+		return fmt.Errorf("request to %s failed: %s", endpoint, response.Error)
+	}
+
+	return json.Unmarshal(response.Data, result)
+}
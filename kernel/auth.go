@@ -0,0 +1,101 @@
+package kernel
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+// Values of the auth option.
+const (
+	authNone = "none"
+	// authPrompt asks for a username and password, which are sent using basic authentication.
+	authPrompt = "prompt"
+	// authBearer asks for a token, which is sent as bearer token.
+	authBearer = "bearer"
+)
+
+var authModes = []string{authNone, authPrompt, authBearer}
+
+// Credentials are sent with every request to the server.
+// They are only kept in memory, so they do not end up in the notebook.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// String returns a description of the credentials without the secrets.
+func (c *Credentials) String() string {
+	if c.Token != "" {
+		return "bearer token"
+	}
+	return fmt.Sprintf("basic (%s)", c.Username)
+}
+
+// authRoundTripper adds credentials to the requests sent by next.
+type authRoundTripper struct {
+	credentials *Credentials
+	next        http.RoundTripper
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request.
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		authReq.Header[key] = values
+	}
+
+	if rt.credentials.Token != "" {
+		authReq.Header.Set("Authorization", "Bearer "+rt.credentials.Token)
+	} else {
+		authReq.SetBasicAuth(rt.credentials.Username, rt.credentials.Password)
+	}
+	return rt.next.RoundTrip(authReq)
+}
+
+// setAuth sets the credentials according to the mode, asking the user for the secrets.
+func setAuth(v **Credentials, value, server string, readInput scaffold.InputFunc) error {
+	mode := strings.ToLower(value)
+	if !containsString(authModes, mode) {
+		return fmt.Errorf("not a valid auth mode: %s (valid modes: %s)", value, strings.Join(authModes, ", "))
+	}
+
+	if mode == authNone {
+		*v = nil
+		return nil
+	}
+
+	if readInput == nil {
+		return fmt.Errorf("can not ask for credentials: %s", scaffold.ErrStdinNotAllowed)
+	}
+	if server == "" {
+		server = "the server"
+	}
+
+	credentials := &Credentials{}
+	var err error
+	switch mode {
+	case authPrompt:
+		credentials.Username, err = readInput(fmt.Sprintf("Username for %s: ", server), false)
+		if err != nil {
+			return fmt.Errorf("can not ask for credentials: %s", err)
+		}
+		credentials.Password, err = readInput("Password: ", true)
+	case authBearer:
+		credentials.Token, err = readInput(fmt.Sprintf("Token for %s: ", server), true)
+		if err == nil && credentials.Token == "" {
+			err = fmt.Errorf("token is empty")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("can not ask for credentials: %s", err)
+	}
+
+	*v = credentials
+	return nil
+}
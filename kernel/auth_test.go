@@ -0,0 +1,189 @@
+package kernel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+// inputFunc returns an InputFunc answering prompts with the given values.
+func inputFunc(values ...string) scaffold.InputFunc {
+	return func(prompt string, password bool) (string, error) {
+		if len(values) == 0 {
+			return "", errors.New("no more input")
+		}
+		value := values[0]
+		values = values[1:]
+		return value, nil
+	}
+}
+
+func TestSetAuth(t *testing.T) {
+	for _, test := range []struct {
+		desc        string
+		value       string
+		readInput   scaffold.InputFunc
+		credentials *Credentials
+		err         bool
+	}{
+		{
+			desc:        "basic",
+			value:       "prompt",
+			readInput:   inputFunc("admin", "secret"),
+			credentials: &Credentials{Username: "admin", Password: "secret"},
+		},
+		{
+			desc:        "bearer",
+			value:       "Bearer",
+			readInput:   inputFunc("token"),
+			credentials: &Credentials{Token: "token"},
+		},
+		{
+			desc:        "none",
+			value:       "none",
+			credentials: nil,
+		},
+		{
+			desc:      "empty token",
+			value:     "bearer",
+			readInput: inputFunc(""),
+			err:       true,
+		},
+		{
+			desc:  "stdin not allowed",
+			value: "prompt",
+			readInput: func(string, bool) (string, error) {
+				return "", scaffold.ErrStdinNotAllowed
+			},
+			err: true,
+		},
+		{
+			desc:  "no input",
+			value: "prompt",
+			err:   true,
+		},
+		{
+			desc:  "invalid mode",
+			value: "password",
+			err:   true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			credentials := &Credentials{Username: "old"}
+			err := setAuth(&credentials, test.value, "http://prometheus:9090", test.readInput)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, wanted error %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(credentials, test.credentials) {
+				t.Errorf("got credentials %#v, wanted %#v", credentials, test.credentials)
+			}
+		})
+	}
+}
+
+func TestAuthRoundTripper(t *testing.T) {
+	for _, test := range []struct {
+		desc          string
+		credentials   *Credentials
+		authorization string
+	}{
+		{
+			desc:          "basic",
+			credentials:   &Credentials{Username: "admin", Password: "secret"},
+			authorization: "Basic YWRtaW46c2VjcmV0",
+		},
+		{
+			desc:          "bearer",
+			credentials:   &Credentials{Token: "token"},
+			authorization: "Bearer token",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			rt := &authRoundTripper{
+				credentials: test.credentials,
+				next:        http.DefaultTransport,
+			}
+			res, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			res.Body.Close()
+
+			if authorization != test.authorization {
+				t.Errorf("got authorization %q, wanted %q", authorization, test.authorization)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("original request was modified")
+			}
+		})
+	}
+}
+
+func TestServerChangeDropsAuth(t *testing.T) {
+	var mutex sync.Mutex
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	k := New("http://prometheus:9090")
+	k.Options.Auth = &Credentials{Token: "secret"}
+
+	if err := k.handleOptions("@server="+server.URL, nil); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if k.Options.Auth != nil {
+		t.Errorf("got credentials %#v after changing the server", k.Options.Auth)
+	}
+
+	client, err := k.getClient()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if _, _, err := client.Do(context.Background(), req); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, authorization := range authorizations {
+		if authorization != "" {
+			t.Errorf("got authorization %q sent to the new server", authorization)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package kernel
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL   = 5 * time.Minute
+	cacheFetchTimeout = 30 * time.Second
+)
+
+type cacheEntry struct {
+	values     []string
+	fetched    time.Time
+	refreshing bool
+}
+
+type fetchFunc func(ctx context.Context) ([]string, error)
+
+// metadataCache caches metadata of a server, like metric and label names, used for completion.
+// Expired entries are still returned but refreshed in the background.
+// The cache is cleared when the server changes.
+type metadataCache struct {
+	mutex   sync.Mutex
+	server  string
+	entries map[string]*cacheEntry
+	nowFunc func() time.Time
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		entries: map[string]*cacheEntry{},
+		nowFunc: time.Now,
+	}
+}
+
+// invalidate removes all entries from the cache.
+func (c *metadataCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = map[string]*cacheEntry{}
+}
+
+// get returns the cached values for key. If there are no values in the cache, they are fetched
+// synchronously. If the values are older than ttl they are refreshed in the background.
+func (c *metadataCache) get(ctx context.Context, server, key string, ttl time.Duration, fetch fetchFunc) ([]string, error) {
+	c.mutex.Lock()
+	if server != c.server {
+		c.server = server
+		c.entries = map[string]*cacheEntry{}
+	}
+
+	// Entries without values are still being prefetched
+	entry, ok := c.entries[key]
+	if ok && entry.values != nil {
+		if c.nowFunc().Sub(entry.fetched) > ttl && !entry.refreshing {
+			entry.refreshing = true
+			go c.refresh(server, key, fetch)
+		}
+
+		values := entry.values
+		c.mutex.Unlock()
+		return values, nil
+	}
+	c.mutex.Unlock()
+
+	values, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(server, key, values)
+	return values, nil
+}
+
+// prefetch fetches the values for key in the background, if they are not cached yet.
+func (c *metadataCache) prefetch(server, key string, fetch fetchFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if server != c.server {
+		c.server = server
+		c.entries = map[string]*cacheEntry{}
+	}
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = &cacheEntry{
+		refreshing: true,
+	}
+	go c.refresh(server, key, fetch)
+}
+
+func (c *metadataCache) refresh(server, key string, fetch fetchFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheFetchTimeout)
+	defer cancel()
+
+	values, err := fetch(ctx)
+	if err != nil {
+		log.Printf("Error refreshing %s: %s", key, err)
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if entry, ok := c.entries[key]; ok && server == c.server {
+			entry.refreshing = false
+			if entry.values == nil {
+				// Failed prefetch, fetch again on next use
+				delete(c.entries, key)
+			}
+		}
+		return
+	}
+
+	c.store(server, key, values)
+}
+
+func (c *metadataCache) store(server, key string, values []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if server != c.server {
+		// Server changed while fetching
+		return
+	}
+
+	c.entries[key] = &cacheEntry{
+		values:  values,
+		fetched: c.nowFunc(),
+	}
+}
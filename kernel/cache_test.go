@@ -0,0 +1,85 @@
+package kernel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFetch returns a fetchFunc, which returns a new value on every call.
+func countingFetch() fetchFunc {
+	var mutex sync.Mutex
+	count := 0
+	return func(_ context.Context) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		count++
+		return []string{string(rune('a' + count - 1))}, nil
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := newMetadataCache()
+	cache.nowFunc = func() time.Time {
+		return now
+	}
+	ctx := context.Background()
+	fetch := countingFetch()
+
+	get := func(server string, want []string) {
+		t.Helper()
+		values, err := cache.get(ctx, server, "metrics", time.Minute, fetch)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("got %v, want %v", values, want)
+		}
+	}
+
+	get("server1", []string{"a"})
+	get("server1", []string{"a"})
+
+	// Expired values are returned while refreshing
+	cache.mutex.Lock()
+	now = now.Add(2 * time.Minute)
+	cache.mutex.Unlock()
+	get("server1", []string{"a"})
+	for i := 0; i < 100; i++ {
+		cache.mutex.Lock()
+		refreshing := cache.entries["metrics"].refreshing
+		cache.mutex.Unlock()
+		if !refreshing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	get("server1", []string{"b"})
+
+	// Changing the server clears the cache
+	get("server2", []string{"c"})
+
+	cache.invalidate()
+	get("server2", []string{"d"})
+}
+
+func TestMetadataCacheError(t *testing.T) {
+	cache := newMetadataCache()
+	fetchErr := errors.New("fetch failed")
+
+	_, err := cache.get(context.Background(), "server", "metrics", time.Minute, func(_ context.Context) ([]string, error) {
+		return nil, fetchErr
+	})
+	if err != fetchErr {
+		t.Errorf("got error %v, want %v", err, fetchErr)
+	}
+
+	if len(cache.entries) != 0 {
+		t.Errorf("failed fetch should not be cached: %v", cache.entries)
+	}
+}
@@ -0,0 +1,144 @@
+package kernel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// commandArgs contains the commands understood by the kernel and the optional arguments they accept.
+var commandArgs = map[string][]string{
+	"explain": {},
+	"format":  {},
+	"graph":   {"unit", "follow"},
+	"graph0":  {"unit", "follow"},
+	"history": {"limit"},
+	"table":   {"unit"},
+	"watch":   {"every", "for", "unit"},
+}
+
+// commandUsage contains the usage shown when inspecting a command.
+var commandUsage = map[string]string{
+	"explain": "explain(<query>)\n\nShows the syntax tree of the query. Every part of the query is evaluated at the end of the time range, showing its type, number of series and some of the values.",
+	"format":  "format(<query>)\n\nPrints the query with consistent indentation and line breaks and replaces the content of the cell with it. Queries containing comments are not formatted.",
+	"graph":   "graph(<query>, unit=<unit>, follow=true)\n\nRuns a range query over the configured time range and shows the result as a graph. With follow=true the graph is updated when the time range is changed using @rangewidget.",
+	"graph0":  "graph0(<query>, unit=<unit>, follow=true)\n\nLike graph, but the Y axis always starts at zero.",
+	"history": "history(<text>, limit=100)\n\nShows the last executed cells containing the text, from this and earlier sessions, as a searchable table. Without text all cells are shown.",
+	"table":   "table(<query>, unit=<unit>)\n\nRuns an instant query at the end of the time range and shows the result as a table. This is the default for queries without a command.",
+	"watch":   "watch(<query>, every=30s, for=1h, unit=<unit>)\n\nShows a graph which is refreshed in the background every 30s for one hour. The graph covers the length of the time range, ending now. Interrupting the kernel or executing the cell again stops the refresh. If the frontend does not send cell IDs, like the classic notebook, starting another watch also stops it.",
+}
+
+// command is a query wrapped in a command, for example graph(<query>, unit=bytes).
+type command struct {
+	Name  string
+	Query string
+	Args  map[string]string
+}
+
+var (
+	commandRegex  = regexp.MustCompile(`(?s)^\s*([a-z]+[0-9]?)\((.*)\)\s*$`)
+	argumentRegex = regexp.MustCompile(`(?s)^\s*([a-z]+)\s*=\s*(.*?)\s*$`)
+)
+
+// parseCommand parses a command from the code of a cell.
+// It returns nil if the code does not contain a command.
+func parseCommand(code string) (*command, error) {
+	match := commandRegex.FindStringSubmatch(code)
+	if match == nil {
+		return nil, nil
+	}
+
+	name := match[1]
+	allowed, ok := commandArgs[name]
+	if !ok {
+		return nil, nil
+	}
+
+	parts, err := splitArguments(match[2])
+	if err != nil {
+		// Something like "graph(a) + graph(b)"
+		return nil, nil
+	}
+
+	cmd := &command{
+		Name:  name,
+		Query: strings.TrimSpace(parts[0]),
+		Args:  map[string]string{},
+	}
+	if cmd.Query == "" && name != "history" {
+		return nil, fmt.Errorf("%s needs a query", name)
+	}
+
+	for _, part := range parts[1:] {
+		arg := argumentRegex.FindStringSubmatch(part)
+		if arg == nil {
+			return nil, fmt.Errorf("not a valid argument for %s: %s", name, strings.TrimSpace(part))
+		}
+
+		key := arg[1]
+		if len(allowed) == 0 {
+			return nil, fmt.Errorf("%s does not accept arguments: %s", name, key)
+		}
+		if !containsString(allowed, key) {
+			return nil, fmt.Errorf("unknown argument for %s: %s (allowed: %s)", name, key, strings.Join(allowed, ", "))
+		}
+		cmd.Args[key] = arg[2]
+	}
+
+	return cmd, nil
+}
+
+// splitArguments splits a string at all commas which are not nested in brackets or strings.
+func splitArguments(input string) ([]string, error) {
+	var (
+		parts []string
+		depth int
+		quote rune
+		start int
+	)
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			switch {
+			case r == '\\' && quote != '`':
+				i++
+			case r == quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch r {
+		case '"', '\'', '`':
+			quote = r
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %q at %d", r, i)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, string(runes[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 || quote != 0 {
+		return nil, fmt.Errorf("unbalanced brackets or quotes")
+	}
+
+	return append(parts, string(runes[start:])), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package kernel
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		cmd   *command
+		err   error
+	}{
+		{
+			desc:  "no command",
+			input: "up",
+			cmd:   nil,
+		},
+		{
+			desc:  "promql function",
+			input: "rate(up[5m])",
+			cmd:   nil,
+		},
+		{
+			desc:  "graph",
+			input: "graph(up)",
+			cmd: &command{
+				Name:  "graph",
+				Query: "up",
+				Args:  map[string]string{},
+			},
+		},
+		{
+			desc:  "graph with zero",
+			input: "graph0(sum by(job) (rate(up[5m])))",
+			cmd: &command{
+				Name:  "graph0",
+				Query: "sum by(job) (rate(up[5m]))",
+				Args:  map[string]string{},
+			},
+		},
+		{
+			desc:  "nested commas",
+			input: `graph(histogram_quantile(0.9, rate(a{b="c,d"}[5m])), unit=seconds)`,
+			cmd: &command{
+				Name:  "graph",
+				Query: `histogram_quantile(0.9, rate(a{b="c,d"}[5m]))`,
+				Args: map[string]string{
+					"unit": "seconds",
+				},
+			},
+		},
+		{
+			desc:  "multiple commands",
+			input: "graph(a) + graph(b)",
+			cmd:   nil,
+		},
+		{
+			desc:  "unknown argument",
+			input: "graph(up, color=red)",
+			err:   errors.New("unknown argument for graph: color (allowed: unit, follow)"),
+		},
+		{
+			desc:  "invalid argument",
+			input: "table(up, 5)",
+			err:   errors.New("not a valid argument for table: 5"),
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			cmd, err := parseCommand(test.input)
+
+			if !reflect.DeepEqual(err, test.err) {
+				t.Errorf("got error %q, want %q", err, test.err)
+			}
+
+			if !reflect.DeepEqual(cmd, test.cmd) {
+				t.Errorf("got command %#v, want %#v", cmd, test.cmd)
+			}
+		})
+	}
+}
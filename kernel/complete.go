@@ -2,35 +2,434 @@ package kernel
 
 import (
 	"context"
+	"log"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// completionContext describes what kind of token is expected at the cursor position.
+type completionContext int
+
+const (
+	completeNothing completionContext = iota
+	completeExpression
+	completeLabelName
+	completeLabelValue
+	completeGrouping
+	completeDuration
+	completeOption
+)
+
+// completionState is the result of analyzing the input at the cursor position.
+type completionState struct {
+	Context completionContext
+	// Prefix is the text between Start and End, which is replaced by the completion.
+	Prefix string
+	Start  int
+	End    int
+	// AtStart is true if the prefix is at the start of the cell.
+	AtStart bool
+	// Metric is the name of the metric of the selector the cursor is in.
+	Metric string
+	// Label is the name of the label whose value is completed.
+	Label string
+	// Metrics contains the names of all metrics used in the input.
+	Metrics []string
+}
+
+// bracket is an opening bracket in the input.
+type bracket struct {
+	char rune
+	// word is the identifier directly in front of the bracket.
+	word string
+}
+
+const (
+	defaultCompletionLimit = 500
+	completionTimeout      = 10 * time.Second
 )
 
+var (
+	labelMatcherRegex = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*$`)
+	groupingKeywords  = []string{"by", "without", "on", "ignoring", "group_left", "group_right"}
+	durations         = []string{"1m", "5m", "10m", "15m", "30m", "1h", "2h", "6h", "12h", "1d", "7d"}
+)
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == ':' || unicode.IsLetter(r)
+}
+
+func isIdentifierRune(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r)
+}
+
+// lastIdentifier returns the identifier in front of pos and its start and end position.
+// Positions are counted in characters, not bytes.
+func lastIdentifier(input string, pos int) (string, int, int) {
+	runes := []rune(input)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+
+	start := pos
+	for start > 0 && isIdentifierRune(runes[start-1]) {
+		start--
+	}
+
+	return string(runes[start:pos]), start, pos
+}
+
+// wordBefore returns the identifier at the end of runes, ignoring whitespace.
+func wordBefore(runes []rune) string {
+	end := len(runes)
+	for end > 0 && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+
+	word, _, _ := lastIdentifier(string(runes[:end]), end)
+	return word
+}
+
+// lastNonSpace returns the last rune in runes which is not whitespace.
+func lastNonSpace(runes []rune) rune {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsSpace(runes[i]) {
+			return runes[i]
+		}
+	}
+	return 0
+}
+
+func isKeyword(word string) bool {
+	return containsString(promql.Keywords, word) || containsString(promql.Aggregations, word) || promql.Functions[word] != nil
+}
+
+// scanResult is the state at the end of scanning an input.
+type scanResult struct {
+	// stack contains the brackets which are still open.
+	stack []bracket
+	// quote is the quote character of an unterminated string.
+	quote      rune
+	quoteStart int
+	// metrics contains the names of all metrics used in the input.
+	metrics map[string]bool
+}
+
+// scanInput tokenizes the input far enough to find open brackets, strings and metric names.
+func scanInput(runes []rune) scanResult {
+	result := scanResult{
+		metrics: map[string]bool{},
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if result.quote != 0 {
+			switch {
+			case r == '\\' && result.quote != '`':
+				i++
+			case r == result.quote:
+				result.quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case r == '"' || r == '\'' || r == '`':
+			result.quote = r
+			result.quoteStart = i
+		case r == '#':
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case r == '(' || r == '{' || r == '[':
+			result.stack = append(result.stack, bracket{r, wordBefore(runes[:i])})
+		case r == ')' || r == '}' || r == ']':
+			if len(result.stack) > 0 {
+				result.stack = result.stack[:len(result.stack)-1]
+			}
+		case unicode.IsDigit(r):
+			for i+1 < len(runes) && (isIdentifierRune(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+		case isIdentifierStart(r):
+			start := i
+			for i+1 < len(runes) && isIdentifierRune(runes[i+1]) {
+				i++
+			}
+			word := string(runes[start : i+1])
+
+			var next rune
+			for j := i + 1; j < len(runes); j++ {
+				if !unicode.IsSpace(runes[j]) {
+					next = runes[j]
+					break
+				}
+			}
+
+			inBrackets := false
+			if len(result.stack) > 0 {
+				top := result.stack[len(result.stack)-1]
+				inBrackets = top.char != '(' || containsString(groupingKeywords, top.word)
+			}
+
+			if !inBrackets && next != '(' && !isKeyword(word) {
+				result.metrics[word] = true
+			}
+		}
+	}
+
+	return result
+}
+
+// analyzeCompletion determines what kind of completion is possible at pos.
+func analyzeCompletion(input string, pos int) completionState {
+	runes := []rune(input)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	before := runes[:pos]
+
+	lineStart := 0
+	for i := len(before) - 1; i >= 0; i-- {
+		if before[i] == '\n' {
+			lineStart = i + 1
+			break
+		}
+	}
+	line := strings.TrimLeftFunc(string(before[lineStart:]), unicode.IsSpace)
+	if strings.HasPrefix(line, "@") {
+		if strings.Contains(line, "=") {
+			return completionState{Context: completeNothing, Start: pos, End: pos}
+		}
+
+		prefix := strings.TrimPrefix(line, "@")
+		return completionState{
+			Context: completeOption,
+			Prefix:  prefix,
+			Start:   pos - len([]rune(prefix)),
+			End:     pos,
+		}
+	}
+
+	scan := scanInput(before)
+	stack, quote, quoteStart := scan.stack, scan.quote, scan.quoteStart
+	metrics := scanInput(runes).metrics
+
+	prefix, start, end := lastIdentifier(string(runes), pos)
+	state := completionState{
+		Context: completeNothing,
+		Prefix:  prefix,
+		Start:   start,
+		End:     end,
+		Metrics: sortedKeys(metrics),
+	}
+
+	var top bracket
+	if len(stack) > 0 {
+		top = stack[len(stack)-1]
+	}
+
+	if quote != 0 {
+		if top.char != '{' {
+			return state
+		}
+
+		if match := labelMatcherRegex.FindStringSubmatch(string(runes[:quoteStart])); match != nil {
+			state.Context = completeLabelValue
+			state.Label = match[1]
+			state.Metric = top.word
+			state.Prefix = string(runes[quoteStart+1 : pos])
+			state.Start = quoteStart + 1
+		}
+		return state
+	}
+
+	switch top.char {
+	case '[':
+		state.Context = completeDuration
+	case '{':
+		if c := lastNonSpace(runes[:start]); c == '{' || c == ',' {
+			state.Context = completeLabelName
+			state.Metric = top.word
+		}
+	case '(':
+		if containsString(groupingKeywords, top.word) {
+			state.Context = completeGrouping
+			break
+		}
+		state.Context = completeExpression
+	default:
+		state.Context = completeExpression
+		state.AtStart = strings.TrimSpace(string(runes[:start])) == ""
+	}
+
+	if state.Context == completeExpression && prefix != "" && unicode.IsDigit([]rune(prefix)[0]) {
+		// Numbers are not completed
+		state.Context = completeNothing
+	}
+
+	return state
+}
+
 func (k *Kernel) handleComplete(input string, cursorPos int) (matches []string, start, end int, err error) {
-	identifier, start, end := lastIdentifier(input, cursorPos)
+	state := analyzeCompletion(input, cursorPos)
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	var candidates []string
+	switch state.Context {
+	case completeOption:
+		for _, name := range optionNames {
+			candidates = append(candidates, name+"=")
+		}
+	case completeDuration:
+		candidates = durations
+	case completeLabelName:
+		var selectors []string
+		if state.Metric != "" {
+			selectors = []string{state.Metric}
+		}
+
+		candidates, err = k.labelNames(ctx, selectors)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	case completeGrouping:
+		candidates, err = k.labelNames(ctx, state.Metrics)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	case completeLabelValue:
+		var selectors []string
+		if state.Metric != "" {
+			selectors = []string{state.Metric}
+		}
+
+		candidates, err = k.labelValues(ctx, state.Label, selectors)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	case completeExpression:
+		// Functions and commands can still be completed without a server.
+		names, err := k.metricNames(ctx)
+		if err != nil {
+			log.Printf("Error getting metric names: %s", err)
+		}
+		candidates = names
+
+		for _, name := range promql.FunctionNames() {
+			candidates = append(candidates, name+"(")
+		}
+		candidates = append(candidates, promql.Aggregations...)
+		candidates = append(candidates, promql.Keywords...)
+
+		if state.AtStart {
+			for name := range commandArgs {
+				candidates = append(candidates, name+"(")
+			}
+			candidates = append(candidates, "@")
+		}
+	}
 
-	api, err := k.getAPI()
-	if err != nil {
-		return nil, 0, 0, err
+	matches = rankMatches(candidates, state.Prefix, k.Options.CompletionLimit)
+	return matches, state.Start, state.End, nil
+}
+
+// Ranks of completion matches, lower is better.
+const (
+	rankPrefix = iota
+	rankPrefixIgnoreCase
+	rankSubstring
+	rankFuzzy
+)
+
+type rankedMatch struct {
+	value string
+	rank  int
+	// score orders matches with the same rank, lower is better.
+	score int
+}
+
+// rankMatch returns the rank of a candidate for the given input.
+// The second return value is false if the candidate does not match at all.
+func rankMatch(candidate, input string) (rank, score int, ok bool) {
+	if strings.HasPrefix(candidate, input) {
+		return rankPrefix, 0, true
 	}
 
-	values, err := api.LabelValues(context.Background(), "__name__")
-	if err != nil {
-		return nil, 0, 0, err
+	lowerCandidate := strings.ToLower(candidate)
+	lowerInput := strings.ToLower(input)
+	if strings.HasPrefix(lowerCandidate, lowerInput) {
+		return rankPrefixIgnoreCase, 0, true
 	}
 
-	metrics := map[string]bool{}
-	for _, value := range values {
-		valueStr := string(value)
-		if strings.HasPrefix(valueStr, identifier) {
-			matches = append(matches, valueStr)
+	if index := strings.Index(lowerCandidate, lowerInput); index >= 0 {
+		return rankSubstring, index, true
+	}
+
+	// Fuzzy: all characters of the input appear in order, the score is the length of the matched span.
+	first, pos := -1, 0
+	inputRunes := []rune(lowerInput)
+	for i, r := range []rune(lowerCandidate) {
+		if pos < len(inputRunes) && r == inputRunes[pos] {
+			if first < 0 {
+				first = i
+			}
+			pos++
+			if pos == len(inputRunes) {
+				return rankFuzzy, i - first, true
+			}
 		}
 	}
 
-	for k := range metrics {
-		matches = append(matches, k)
+	return 0, 0, false
+}
+
+// rankMatches returns the de-duplicated candidates matching the input, ordered by relevance.
+// Prefix matches come first, followed by substring and fuzzy matches. At most limit matches are returned.
+func rankMatches(candidates []string, input string, limit int) []string {
+	seen := map[string]bool{}
+	ranked := []rankedMatch{}
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+
+		if rank, score, ok := rankMatch(c, input); ok {
+			ranked = append(ranked, rankedMatch{c, rank, score})
+		}
 	}
-	sort.Strings(matches)
 
-	return matches, start, end, nil
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if len(a.value) != len(b.value) && a.rank != rankPrefix {
+			return len(a.value) < len(b.value)
+		}
+		return a.value < b.value
+	})
+
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	matches := make([]string, len(ranked))
+	for i, m := range ranked {
+		matches[i] = m.value
+	}
+	return matches
 }
@@ -0,0 +1,268 @@
+package kernel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeCompletion(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		pos   int
+		state completionState
+	}{
+		{
+			desc:  "empty",
+			input: "",
+			pos:   0,
+			state: completionState{
+				Context: completeExpression,
+				AtStart: true,
+				Metrics: []string{},
+			},
+		},
+		{
+			desc:  "metric name",
+			input: "node_cpu",
+			pos:   8,
+			state: completionState{
+				Context: completeExpression,
+				Prefix:  "node_cpu",
+				Start:   0,
+				End:     8,
+				AtStart: true,
+				Metrics: []string{"node_cpu"},
+			},
+		},
+		{
+			desc:  "function argument",
+			input: "rate(node_cpu",
+			pos:   13,
+			state: completionState{
+				Context: completeExpression,
+				Prefix:  "node_cpu",
+				Start:   5,
+				End:     13,
+				Metrics: []string{"node_cpu"},
+			},
+		},
+		{
+			desc:  "label name",
+			input: `up{job="node", inst`,
+			pos:   19,
+			state: completionState{
+				Context: completeLabelName,
+				Prefix:  "inst",
+				Start:   15,
+				End:     19,
+				Metric:  "up",
+				Metrics: []string{"up"},
+			},
+		},
+		{
+			desc:  "label value",
+			input: `up{job="no`,
+			pos:   10,
+			state: completionState{
+				Context: completeLabelValue,
+				Prefix:  "no",
+				Start:   8,
+				End:     10,
+				Metric:  "up",
+				Label:   "job",
+				Metrics: []string{"up"},
+			},
+		},
+		{
+			desc:  "label value with regex",
+			input: `{job=~"`,
+			pos:   7,
+			state: completionState{
+				Context: completeLabelValue,
+				Prefix:  "",
+				Start:   7,
+				End:     7,
+				Label:   "job",
+				Metrics: []string{},
+			},
+		},
+		{
+			desc:  "grouping",
+			input: "sum by (j) (rate(http_requests_total[5m]))",
+			pos:   9,
+			state: completionState{
+				Context: completeGrouping,
+				Prefix:  "j",
+				Start:   8,
+				End:     9,
+				Metrics: []string{"http_requests_total"},
+			},
+		},
+		{
+			desc:  "duration",
+			input: "rate(up[5",
+			pos:   9,
+			state: completionState{
+				Context: completeDuration,
+				Prefix:  "5",
+				Start:   8,
+				End:     9,
+				Metrics: []string{"up"},
+			},
+		},
+		{
+			desc:  "option",
+			input: "@server=http://localhost:9090\n@st",
+			pos:   33,
+			state: completionState{
+				Context: completeOption,
+				Prefix:  "st",
+				Start:   31,
+				End:     33,
+			},
+		},
+		{
+			desc:  "option value",
+			input: "@server=http",
+			pos:   12,
+			state: completionState{
+				Context: completeNothing,
+				Start:   12,
+				End:     12,
+			},
+		},
+		{
+			desc:  "number",
+			input: "up > 10",
+			pos:   7,
+			state: completionState{
+				Context: completeNothing,
+				Prefix:  "10",
+				Start:   5,
+				End:     7,
+				Metrics: []string{"up"},
+			},
+		},
+		{
+			desc:  "unicode",
+			input: `up{job="ä"} + no`,
+			pos:   16,
+			state: completionState{
+				Context: completeExpression,
+				Prefix:  "no",
+				Start:   14,
+				End:     16,
+				Metrics: []string{"no", "up"},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			state := analyzeCompletion(test.input, test.pos)
+			if !reflect.DeepEqual(state, test.state) {
+				t.Errorf("got %+v, want %+v", state, test.state)
+			}
+		})
+	}
+}
+
+func TestRankMatches(t *testing.T) {
+	candidates := []string{
+		"node_cpu_seconds_total",
+		"process_cpu_seconds_total",
+		"node_memory_MemFree_bytes",
+		"Node_info",
+		"rate(",
+		"node_cpu_seconds_total",
+	}
+
+	for _, test := range []struct {
+		desc    string
+		input   string
+		limit   int
+		matches []string
+	}{
+		{
+			desc:    "empty",
+			input:   "",
+			limit:   0,
+			matches: []string{"Node_info", "node_cpu_seconds_total", "node_memory_MemFree_bytes", "process_cpu_seconds_total", "rate("},
+		},
+		{
+			desc:    "prefix first",
+			input:   "node",
+			limit:   0,
+			matches: []string{"node_cpu_seconds_total", "node_memory_MemFree_bytes", "Node_info"},
+		},
+		{
+			desc:    "substring",
+			input:   "cpu",
+			limit:   0,
+			matches: []string{"node_cpu_seconds_total", "process_cpu_seconds_total"},
+		},
+		{
+			desc:    "fuzzy",
+			input:   "nmf",
+			limit:   0,
+			matches: []string{"node_memory_MemFree_bytes"},
+		},
+		{
+			desc:    "limit",
+			input:   "node",
+			limit:   1,
+			matches: []string{"node_cpu_seconds_total"},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			matches := rankMatches(candidates, test.input, test.limit)
+			if !reflect.DeepEqual(matches, test.matches) {
+				t.Errorf("got %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestCompleteWithoutServer(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	for _, test := range []struct {
+		desc   string
+		server string
+	}{
+		{
+			desc:   "no server",
+			server: "",
+		},
+		{
+			desc:   "failing server",
+			server: failing.URL,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			k := New(test.server)
+			matches, start, end, err := k.handleComplete("gra", 3)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if len(matches) == 0 || matches[0] != "graph(" {
+				t.Errorf("got matches %v, wanted graph( first", matches)
+			}
+			if start != 0 || end != 3 {
+				t.Errorf("got range %d-%d, wanted 0-3", start, end)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package kernel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// maxExplainSamples is the number of series shown for every node of an explained query.
+const maxExplainSamples = 3
+
+// explainNode is a sub-expression of an explained query together with its evaluation result.
+type explainNode struct {
+	// Label describes the kind of node, for example "aggregation sum by (job)".
+	Label string
+	Expr  string
+	Type  promql.ValueType
+	// Evaluated is false for literals, which are not sent to the server.
+	Evaluated bool
+	Series    int
+	Samples   []string
+	Err       error
+	Children  []*explainNode
+}
+
+type evaluateFunc func(query string) (model.Value, error)
+
+// buildExplainTree converts the expression to a tree and evaluates every node using evaluate.
+func buildExplainTree(expr promql.Expr, evaluate evaluateFunc) *explainNode {
+	node := &explainNode{
+		Label: explainLabel(expr),
+		Expr:  promql.String(expr),
+		Type:  expr.Type(),
+	}
+
+	switch expr.(type) {
+	case *promql.NumberLiteral, *promql.StringLiteral:
+	default:
+		node.Evaluated = true
+		value, err := evaluate(node.Expr)
+		if err != nil {
+			node.Err = err
+		} else {
+			node.Series, node.Samples = summarizeValue(value)
+		}
+	}
+
+	for _, child := range promql.Children(expr) {
+		node.Children = append(node.Children, buildExplainTree(child, evaluate))
+	}
+	return node
+}
+
+// explainLabel describes the kind of an expression.
+func explainLabel(expr promql.Expr) string {
+	switch e := expr.(type) {
+	case *promql.NumberLiteral:
+		return "number"
+	case *promql.StringLiteral:
+		return "string"
+	case *promql.VectorSelector:
+		return "instant vector selector"
+	case *promql.MatrixSelector:
+		return "range vector selector [" + promql.FormatDuration(e.Range) + "]"
+	case *promql.SubqueryExpr:
+		return "subquery"
+	case *promql.Call:
+		return "function " + e.Func.Name
+	case *promql.AggregateExpr:
+		return "aggregation " + strings.TrimSpace(promql.AggregationHead(e))
+	case *promql.BinaryExpr:
+		return "binary operator " + promql.OperatorString(e)
+	case *promql.UnaryExpr:
+		return "unary operator " + e.Op
+	case *promql.ParenExpr:
+		return "parentheses"
+	}
+	return "expression"
+}
+
+// summarizeValue returns the number of series of a query result and a few formatted samples.
+func summarizeValue(value model.Value) (int, []string) {
+	var samples []string
+	switch v := value.(type) {
+	case model.Vector:
+		sortVector(v, "-"+sortByValue)
+		for i := 0; i < len(v) && i < maxExplainSamples; i++ {
+			samples = append(samples, fmt.Sprintf("%s => %s", v[i].Metric, formatValue(v[i].Value)))
+		}
+		return len(v), samples
+	case model.Matrix:
+		for i := 0; i < len(v) && i < maxExplainSamples; i++ {
+			values := v[i].Values
+			if len(values) == 0 {
+				continue
+			}
+			samples = append(samples, fmt.Sprintf("%s => %s (%d points)", v[i].Metric, formatValue(values[len(values)-1].Value), len(values)))
+		}
+		return len(v), samples
+	case *model.Scalar:
+		return 1, []string{formatValue(v.Value)}
+	case *model.String:
+		return 1, []string{v.Value}
+	}
+	return 0, nil
+}
+
+func (n *explainNode) summary() string {
+	switch {
+	case n.Err != nil:
+		return fmt.Sprintf("%s, error: %s", n.Type, n.Err)
+	case !n.Evaluated:
+		return string(n.Type)
+	}
+	return fmt.Sprintf("%s, %d series", n.Type, n.Series)
+}
+
+// HTML renders the tree as nested lists.
+func (n *explainNode) HTML(evaluated time.Time, loc *time.Location) string {
+	output := &bytes.Buffer{}
+	fmt.Fprintf(output, "<p>Evaluated at %s</p>\n<ul>", html.EscapeString(evaluated.In(loc).Format(time.RFC3339)))
+	n.writeHTML(output)
+	fmt.Fprint(output, "</ul>")
+	return output.String()
+}
+
+func (n *explainNode) writeHTML(output *bytes.Buffer) {
+	fmt.Fprintf(output, "<li><details open><summary><b>%s</b>: <code>%s</code> (%s)</summary>",
+		html.EscapeString(n.Label), html.EscapeString(n.Expr), html.EscapeString(n.summary()))
+	if len(n.Samples) > 0 && n.Evaluated {
+		fmt.Fprint(output, "<ul>")
+		for _, s := range n.Samples {
+			fmt.Fprintf(output, "<li><code>%s</code></li>", html.EscapeString(s))
+		}
+		fmt.Fprint(output, "</ul>")
+	}
+	if len(n.Children) > 0 {
+		fmt.Fprint(output, "<ul>")
+		for _, child := range n.Children {
+			child.writeHTML(output)
+		}
+		fmt.Fprint(output, "</ul>")
+	}
+	fmt.Fprintln(output, "</details></li>")
+}
+
+// Text renders the tree as indented plain text.
+func (n *explainNode) Text() string {
+	output := &bytes.Buffer{}
+	n.writeText(output, "")
+	return output.String()
+}
+
+func (n *explainNode) writeText(output *bytes.Buffer, indent string) {
+	fmt.Fprintf(output, "%s%s: %s (%s)\n", indent, n.Label, n.Expr, n.summary())
+	if n.Evaluated {
+		for _, s := range n.Samples {
+			fmt.Fprintf(output, "%s  | %s\n", indent, s)
+		}
+	}
+	for _, child := range n.Children {
+		child.writeText(output, indent+"  ")
+	}
+}
+
+func (k *Kernel) handleExplain(ctx context.Context, expr promql.Expr, instant time.Time) (*explainNode, error) {
+	api, err := k.getAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	return buildExplainTree(expr, func(query string) (model.Value, error) {
+		return evaluateInstant(ctx, api, query, instant)
+	}), nil
+}
+
+func evaluateInstant(ctx context.Context, api promv1.API, query string, instant time.Time) (model.Value, error) {
+	value, err := api.Query(ctx, query, instant)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %s", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,46 @@
+package kernel
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+)
+
+func TestExplainTree(t *testing.T) {
+	results := map[string]model.Value{
+		"a / on (job) group_left b": model.Vector{
+			{Metric: model.Metric{"job": "api"}, Value: 0.5},
+		},
+		"a": model.Vector{
+			{Metric: model.Metric{"job": "api"}, Value: 1},
+			{Metric: model.Metric{"job": "db"}, Value: 3},
+		},
+		"b": model.Vector{},
+	}
+
+	expr, err := promql.ParseExpr("a / on(job) group_left b * 2")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	tree := buildExplainTree(expr, func(query string) (model.Value, error) {
+		if value, ok := results[query]; ok {
+			return value, nil
+		}
+		return model.Vector{}, nil
+	})
+
+	expected := `binary operator *: a / on (job) group_left b * 2 (instant vector, 0 series)
+  binary operator / on (job) group_left: a / on (job) group_left b (instant vector, 1 series)
+    | {job="api"} => 0.5
+    instant vector selector: a (instant vector, 2 series)
+      | {job="db"} => 3
+      | {job="api"} => 1
+    instant vector selector: b (instant vector, 0 series)
+  number: 2 (scalar)
+`
+	if text := tree.Text(); text != expected {
+		t.Errorf("got\n%s\nwanted\n%s", text, expected)
+	}
+}
@@ -0,0 +1,223 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/gonum/plot/palette/brewer"
+	"github.com/prometheus/common/model"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+const (
+	imageWidth  = 640
+	imageHeight = 480
+)
+
+// Only show important part of metric name
+var labelText = regexp.MustCompile("\\{(.*)\\}")
+
+// timeTickFormat returns a format for the time axis labels which fits the displayed duration.
+func timeTickFormat(duration time.Duration) string {
+	switch {
+	case duration <= 15*time.Minute:
+		return "15:04:05"
+	case duration <= 36*time.Hour:
+		return "15:04"
+	case duration <= 7*24*time.Hour:
+		return "Jan 02 15:04"
+	default:
+		return "2006-01-02"
+	}
+}
+
+// seriesSegments splits the samples of a series into continuous segments which can be drawn as lines.
+// A segment ends at a NaN or infinite value and when two consecutive samples are further apart than step.
+// Infinite values are returned separately, so that they can be marked on the plot.
+func seriesSegments(values []model.SamplePair, step time.Duration) (segments []plotter.XYs, infinite plotter.XYs) {
+	var current plotter.XYs
+	var last model.Time
+	flush := func() {
+		if len(current) > 0 {
+			segments = append(segments, current)
+			current = nil
+		}
+	}
+
+	for _, v := range values {
+		x := float64(v.Timestamp.Unix())
+		y := float64(v.Value)
+
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			flush()
+			if math.IsInf(y, 0) {
+				infinite = append(infinite, struct{ X, Y float64 }{x, y})
+			}
+			continue
+		}
+
+		if len(current) > 0 && step > 0 && v.Timestamp.Sub(last) > step {
+			flush()
+		}
+
+		current = append(current, struct{ X, Y float64 }{x, y})
+		last = v.Timestamp
+	}
+	flush()
+
+	return segments, infinite
+}
+
+// graphOptions controls how a query result is plotted.
+type graphOptions struct {
+	// Zero sets the minimum of the Y axis to zero.
+	Zero     bool
+	Step     time.Duration
+	Duration time.Duration
+	Location *time.Location
+	Unit     string
+}
+
+func plotResult(metrics model.Matrix, options graphOptions) ([]byte, error) {
+	p, err := plot.New()
+	if err != nil {
+		return nil, fmt.Errorf("error creating plotter: %s", err)
+	}
+
+	textFont, err := vg.MakeFont("Helvetica", 3*vg.Millimeter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %v", err)
+	}
+
+	p.X.Tick.Marker = plot.TimeTicks{
+		Format: timeTickFormat(options.Duration),
+		Time:   plot.UnixTimeIn(options.Location),
+	}
+	p.X.Label.Text = options.Location.String()
+	p.X.Label.Font = textFont
+	p.X.Tick.Label.Font = textFont
+	p.Y.Tick.Label.Font = textFont
+	p.Y.Tick.Marker = unitTicks{Unit: options.Unit}
+	p.Legend.Font = textFont
+	p.Legend.YOffs = 10 * vg.Millimeter
+
+	if options.Zero {
+		p.Y.Min = 0
+	}
+
+	// Color palette for drawing lines
+	paletteSize := 8
+	palette, err := brewer.GetPalette(brewer.TypeAny, "Dark2", paletteSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get color palette: %v", err)
+	}
+	colors := palette.Colors()
+
+	infiniteSeries := []infiniteValues{}
+	for s, sample := range metrics {
+		seriesColor := colors[s%paletteSize]
+		segments, infinite := seriesSegments(sample.Values, options.Step)
+
+		var legendThumb plot.Thumbnailer
+		for _, segment := range segments {
+			if len(segment) == 1 {
+				// A single sample can not be drawn as a line
+				point, err := newPointMarker(segment, seriesColor, draw.CircleGlyph{})
+				if err != nil {
+					return nil, err
+				}
+				p.Add(point)
+				if legendThumb == nil {
+					legendThumb = point
+				}
+				continue
+			}
+
+			l, err := plotter.NewLine(segment)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create line: %v", err)
+			}
+			l.LineStyle.Width = vg.Points(1)
+			l.LineStyle.Color = seriesColor
+
+			p.Add(l)
+			legendThumb = l
+		}
+
+		if len(infinite) > 0 {
+			// Markers are created once all lines are added, so that they can be clamped to the axis range.
+			infiniteSeries = append(infiniteSeries, infiniteValues{infinite, seriesColor})
+		}
+
+		if len(metrics) > 1 && legendThumb != nil {
+			m := labelText.FindStringSubmatch(sample.Metric.String())
+			if m != nil {
+				p.Legend.Add(m[1], legendThumb)
+			}
+		}
+	}
+
+	if len(infiniteSeries) > 0 {
+		if p.Y.Min > p.Y.Max {
+			// Only infinite values, no finite range available
+			p.Y.Min, p.Y.Max = 0, 1
+		}
+
+		for _, series := range infiniteSeries {
+			points := make(plotter.XYs, len(series.points))
+			for i, v := range series.points {
+				points[i].X = v.X
+				points[i].Y = p.Y.Min
+				if math.IsInf(v.Y, 1) {
+					points[i].Y = p.Y.Max
+				}
+			}
+
+			marker, err := newPointMarker(points, series.color, draw.TriangleGlyph{})
+			if err != nil {
+				return nil, err
+			}
+			p.Add(marker)
+		}
+	}
+
+	c, err := draw.NewFormattedCanvas(imageWidth, imageHeight, "png")
+	if err != nil {
+		return nil, fmt.Errorf("error creating canvas: %s", err)
+	}
+
+	p.Draw(draw.New(c))
+
+	buf := &bytes.Buffer{}
+	if _, err := c.WriteTo(buf); err != nil {
+		return nil, fmt.Errorf("error writing image: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// infiniteValues holds the infinite samples of a series.
+type infiniteValues struct {
+	points plotter.XYs
+	color  color.Color
+}
+
+// newPointMarker creates a scatter plot used for marking single points.
+func newPointMarker(points plotter.XYs, c color.Color, shape draw.GlyphDrawer) (*plotter.Scatter, error) {
+	s, err := plotter.NewScatter(points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create marker: %v", err)
+	}
+	s.GlyphStyle.Color = c
+	s.GlyphStyle.Shape = shape
+	s.GlyphStyle.Radius = vg.Points(2)
+
+	return s, nil
+}
@@ -0,0 +1,149 @@
+package kernel
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gonum.org/v1/plot/plotter"
+)
+
+func samples(values ...float64) []model.SamplePair {
+	result := make([]model.SamplePair, len(values))
+	for i, v := range values {
+		result[i] = model.SamplePair{
+			Timestamp: model.TimeFromUnix(int64(i * 60)),
+			Value:     model.SampleValue(v),
+		}
+	}
+	return result
+}
+
+func TestTimeTickFormat(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		duration time.Duration
+		format   string
+	}{
+		{
+			desc:     "few minutes",
+			duration: 5 * time.Minute,
+			format:   "15:04:05",
+		},
+		{
+			desc:     "hours",
+			duration: 12 * time.Hour,
+			format:   "15:04",
+		},
+		{
+			desc:     "day",
+			duration: 24 * time.Hour,
+			format:   "15:04",
+		},
+		{
+			desc:     "few days",
+			duration: 3 * 24 * time.Hour,
+			format:   "Jan 02 15:04",
+		},
+		{
+			desc:     "month",
+			duration: 30 * 24 * time.Hour,
+			format:   "2006-01-02",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			format := timeTickFormat(test.duration)
+			if format != test.format {
+				t.Errorf("got format %q, want %q", format, test.format)
+			}
+		})
+	}
+}
+
+func TestSeriesSegments(t *testing.T) {
+	gap := samples(1, 2, 3, 4)
+	gap = append(gap[:2], gap[3:]...)
+
+	for _, test := range []struct {
+		desc     string
+		values   []model.SamplePair
+		step     time.Duration
+		segments []plotter.XYs
+		infinite plotter.XYs
+	}{
+		{
+			desc:     "empty",
+			values:   samples(),
+			step:     time.Minute,
+			segments: nil,
+			infinite: nil,
+		},
+		{
+			desc:   "continuous",
+			values: samples(1, 2, 3),
+			step:   time.Minute,
+			segments: []plotter.XYs{
+				{{X: 0, Y: 1}, {X: 60, Y: 2}, {X: 120, Y: 3}},
+			},
+		},
+		{
+			desc:   "nan",
+			values: samples(1, 2, math.NaN(), 4),
+			step:   time.Minute,
+			segments: []plotter.XYs{
+				{{X: 0, Y: 1}, {X: 60, Y: 2}},
+				{{X: 180, Y: 4}},
+			},
+		},
+		{
+			desc:   "infinite",
+			values: samples(1, math.Inf(1), 3, math.Inf(-1)),
+			step:   time.Minute,
+			segments: []plotter.XYs{
+				{{X: 0, Y: 1}},
+				{{X: 120, Y: 3}},
+			},
+			infinite: plotter.XYs{
+				{X: 60, Y: math.Inf(1)},
+				{X: 180, Y: math.Inf(-1)},
+			},
+		},
+		{
+			desc:   "gap",
+			values: gap,
+			step:   time.Minute,
+			segments: []plotter.XYs{
+				{{X: 0, Y: 1}, {X: 60, Y: 2}},
+				{{X: 180, Y: 4}},
+			},
+		},
+		{
+			desc:   "gap without step",
+			values: gap,
+			step:   0,
+			segments: []plotter.XYs{
+				{{X: 0, Y: 1}, {X: 60, Y: 2}, {X: 180, Y: 4}},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			segments, infinite := seriesSegments(test.values, test.step)
+
+			if !reflect.DeepEqual(segments, test.segments) {
+				t.Errorf("got segments %v, want %v", segments, test.segments)
+			}
+
+			if !reflect.DeepEqual(infinite, test.infinite) {
+				t.Errorf("got infinite %v, want %v", infinite, test.infinite)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package kernel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+const (
+	// defaultHistoryLimit is the number of entries shown by the history command.
+	defaultHistoryLimit = 100
+	// maxHistoryOutput is the number of bytes of the output saved for an execution.
+	maxHistoryOutput = 4096
+	// maxHistoryCode is the number of bytes of the code and query saved for an execution.
+	// Together with the output, a record stays below the line limit used when reading the history.
+	maxHistoryCode = 16384
+)
+
+// historyEntry is an executed cell.
+type historyEntry struct {
+	Session        int `json:"session"`
+	ExecutionCount int `json:"execution_count"`
+	// Code is the code of the cell. Code and Query are shortened to maxHistoryCode bytes.
+	Code string `json:"code"`
+	// Query is the executed query, including the matchers added using @matchers.
+	Query  string    `json:"query,omitempty"`
+	Server string    `json:"server,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Time is the time the execution started.
+	Time time.Time `json:"time"`
+	// Duration of the execution in seconds.
+	Duration float64 `json:"duration"`
+	Status   string  `json:"status"`
+	// Output is the plain text result or error of the execution, shortened to maxHistoryOutput bytes.
+	Output string `json:"output,omitempty"`
+	// ReservedBy is only set on the record which starts a session. It contains a random ID of
+	// the kernel, which reserved the session.
+	ReservedBy string `json:"reserved_by,omitempty"`
+}
+
+// history contains the executed cells of all sessions. If it has a path, the entries are persisted in
+// a file containing an entry per line.
+type history struct {
+	mutex   sync.Mutex
+	path    string
+	session int
+	entries []*historyEntry
+}
+
+func newHistory() *history {
+	return &history{
+		session: 1,
+	}
+}
+
+// DefaultHistoryFile returns the path of the history file in the Jupyter data directory.
+func DefaultHistoryFile() string {
+	return filepath.Join(scaffold.DataDir(), "ipromnb", "history.jsonl")
+}
+
+// OpenHistory loads the history from the file and appends executed cells to it.
+// The kernel starts a new session following the last session in the file.
+func (k *Kernel) OpenHistory(path string) error {
+	return k.history.open(path)
+}
+
+func (h *history) open(path string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	records, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := reserveSession(path, lastSession(records)+1)
+	if err != nil {
+		return fmt.Errorf("can not start history session: %s", err)
+	}
+
+	for _, r := range records {
+		if r.ReservedBy == "" {
+			h.entries = append(h.entries, r)
+		}
+	}
+	h.session = session
+	h.path = path
+	return nil
+}
+
+// readHistory reads all records of a history file. A missing file is an empty history.
+func readHistory(path string) ([]*historyEntry, error) {
+	file, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("can not open history: %s", err)
+	}
+	defer file.Close()
+
+	var records []*historyEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Skipping invalid history entry: %s", err)
+			continue
+		}
+		records = append(records, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can not read history: %s", err)
+	}
+	return records, nil
+}
+
+func lastSession(records []*historyEntry) int {
+	last := 0
+	for _, r := range records {
+		if r.Session > last {
+			last = r.Session
+		}
+	}
+	return last
+}
+
+// reserveSession appends a record reserving the session to the file. Kernels started at the same time
+// can try to reserve the same session, the first record in the file wins and the others try the next session.
+func reserveSession(path string, session int) (int, error) {
+	id := newDisplayID()
+	for {
+		if err := appendHistory(path, &historyEntry{Session: session, Time: time.Now(), ReservedBy: id}); err != nil {
+			return 0, err
+		}
+
+		records, err := readHistory(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range records {
+			if r.Session == session && r.ReservedBy != "" {
+				if r.ReservedBy == id {
+					return session, nil
+				}
+				break
+			}
+		}
+		session = lastSession(records) + 1
+	}
+}
+
+// add records an executed cell of the current session.
+func (h *history) add(entry *historyEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry.Session = h.session
+	entry.Code = shorten(entry.Code, maxHistoryCode)
+	entry.Query = shorten(entry.Query, maxHistoryCode)
+	entry.Output = shorten(entry.Output, maxHistoryOutput)
+	h.entries = append(h.entries, entry)
+	if h.path == "" {
+		return
+	}
+
+	if err := appendHistory(h.path, entry); err != nil {
+		log.Printf("Error saving history: %s", err)
+	}
+}
+
+// shorten cuts s to at most max bytes without splitting a character and marks it as shortened.
+func shorten(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	end := max
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
+}
+
+func appendHistory(path string, entry *historyEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tail returns the last n entries. All entries are returned if n is not positive.
+func (h *history) tail(n int) []*historyEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return lastEntries(h.entries, n)
+}
+
+// sessionRange returns the entries of a session with an execution count from start up to, but excluding, stop.
+// Sessions which are not positive count back from the current session. If stop is not positive, the
+// range is open.
+func (h *history) sessionRange(session, start, stop int) []*historyEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if session <= 0 {
+		session += h.session
+	}
+	var result []*historyEntry
+	for _, e := range h.entries {
+		if e.Session == session && e.ExecutionCount >= start && (stop <= 0 || e.ExecutionCount < stop) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// search returns the last n entries with code matching the glob pattern.
+// If unique is true, only the last execution of the same code is returned.
+func (h *history) search(pattern string, n int, unique bool) []*historyEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	regex := globRegex(pattern)
+	seen := map[string]bool{}
+	var result []*historyEntry
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		e := h.entries[i]
+		if !regex.MatchString(e.Code) || (unique && seen[e.Code]) {
+			continue
+		}
+		seen[e.Code] = true
+		result = append([]*historyEntry{e}, result...)
+		if n > 0 && len(result) == n {
+			break
+		}
+	}
+	return result
+}
+
+// filter returns the last n entries with code containing the text, ignoring case.
+func (h *history) filter(text string, n int) []*historyEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	text = strings.ToLower(text)
+	var result []*historyEntry
+	for _, e := range h.entries {
+		if strings.Contains(strings.ToLower(e.Code), text) {
+			result = append(result, e)
+		}
+	}
+	return lastEntries(result, n)
+}
+
+func lastEntries(entries []*historyEntry, n int) []*historyEntry {
+	if n <= 0 || n >= len(entries) {
+		return append([]*historyEntry{}, entries...)
+	}
+	return append([]*historyEntry{}, entries[len(entries)-n:]...)
+}
+
+// globRegex converts a glob pattern with * and ? as wildcards to a regular expression matching the whole text.
+func globRegex(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.Replace(quoted, `\*`, ".*", -1)
+	quoted = strings.Replace(quoted, `\?`, ".", -1)
+	return regexp.MustCompile("(?s)^" + quoted + "$")
+}
+
+// HandleHistory implements scaffold.RequestHandlers.
+func (k *Kernel) HandleHistory(req *scaffold.HistoryRequest) *scaffold.HistoryReply {
+	var entries []*historyEntry
+	switch req.HistAccessType {
+	case "tail":
+		entries = k.history.tail(req.N)
+	case "range":
+		entries = k.history.sessionRange(req.Session, req.Start, req.Stop)
+	case "search":
+		entries = k.history.search(req.Pattern, req.N, req.Unique)
+	default:
+		log.Printf("Unknown history access type: %s", req.HistAccessType)
+		return &scaffold.HistoryReply{Status: "error"}
+	}
+
+	reply := &scaffold.HistoryReply{
+		Status:  "ok",
+		History: make([]scaffold.HistoryEntry, len(entries)),
+	}
+	for i, e := range entries {
+		reply.History[i] = scaffold.HistoryEntry{
+			Session:       e.Session,
+			Line:          e.ExecutionCount,
+			Input:         e.Code,
+			IncludeOutput: req.Output,
+			Output:        e.Output,
+		}
+	}
+	return reply
+}
+
+// handleHistory shows the executed cells containing the text of the command as a table.
+func (k *Kernel) handleHistory(cmd *command) (*scaffold.DisplayData, error) {
+	limit := defaultHistoryLimit
+	if value, ok := cmd.Args["limit"]; ok {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return nil, fmt.Errorf("not a valid limit: %s", value)
+		}
+	}
+
+	entries := k.history.filter(unquoteHistoryText(cmd.Query), limit)
+	loc := k.Options.TimeZone()
+	return &scaffold.DisplayData{
+		Data: map[string]interface{}{
+			"text/html":  historyHTML(entries, loc),
+			"text/plain": historyText(entries, loc),
+		},
+	}, nil
+}
+
+// unquoteHistoryText removes the quotes around the search text, if there are any.
+func unquoteHistoryText(text string) string {
+	if len(text) >= 2 && strings.ContainsAny(text[:1], "\"'`") && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
+
+func historyRange(e *historyEntry, loc *time.Location) string {
+	if e.Start.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%s - %s", e.Start.In(loc).Format(time.RFC3339), e.End.In(loc).Format(time.RFC3339))
+}
+
+func historyText(entries []*historyEntry, loc *time.Location) string {
+	if len(entries) == 0 {
+		return "No matching history entries."
+	}
+
+	output := &bytes.Buffer{}
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Time\tCell\tCode\tServer\tRange\tDuration\tStatus")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\t%s\t%.1fs\t%s\n", e.Time.In(loc).Format(time.RFC3339), e.Session, e.ExecutionCount,
+			strings.Replace(e.Code, "\n", " ", -1), e.Server, historyRange(e, loc), e.Duration, e.Status)
+	}
+	w.Flush()
+	return strings.TrimSuffix(output.String(), "\n")
+}
+
+func historyHTML(entries []*historyEntry, loc *time.Location) string {
+	id := "ipromnb-history-" + newDisplayID()
+
+	output := &bytes.Buffer{}
+	fmt.Fprintf(output, "<div id=\"%s\">\n<input type=\"search\" placeholder=\"Search\">\n", id)
+	fmt.Fprintln(output, "<table><thead><tr><th>Time</th><th>Cell</th><th>Code</th><th>Server</th><th>Range</th><th>Duration</th><th>Status</th></tr></thead><tbody>")
+	for _, e := range entries {
+		fmt.Fprintf(output, "<tr><td>%s</td><td>%d/%d</td><td><pre>%s</pre></td><td>%s</td><td>%s</td><td>%.1fs</td><td>%s</td></tr>\n",
+			html.EscapeString(e.Time.In(loc).Format(time.RFC3339)), e.Session, e.ExecutionCount, html.EscapeString(e.Code),
+			html.EscapeString(e.Server), html.EscapeString(historyRange(e, loc)), e.Duration, html.EscapeString(e.Status))
+	}
+	fmt.Fprintln(output, "</tbody></table>\n</div>")
+	fmt.Fprintf(output, historySearchScript, id)
+	return output.String()
+}
+
+const historySearchScript = `<script>
+(function() {
+  var root = document.getElementById("%s");
+  root.querySelector("input").oninput = function() {
+    var text = this.value.toLowerCase();
+    root.querySelectorAll("tbody tr").forEach(function(row) {
+      row.style.display = row.textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+    });
+  };
+})();
+</script>`
@@ -0,0 +1,204 @@
+package kernel
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+func TestHistoryPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipromnb-history")
+	if err != nil {
+		t.Fatalf("can not create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ipromnb", "history.jsonl")
+
+	first := newHistory()
+	if err := first.open(path); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	first.add(&historyEntry{ExecutionCount: 1, Code: "up", Status: "ok"})
+	first.add(&historyEntry{ExecutionCount: 2, Code: "rate(x[5m])", Status: "error"})
+	// Long cells are shortened, so that the records can be read again.
+	long := strings.Repeat(`"`, 1024*1024)
+	first.add(&historyEntry{ExecutionCount: 3, Code: long, Query: long, Output: long, Status: "ok"})
+
+	second := newHistory()
+	if err := second.open(path); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if second.session != 2 {
+		t.Errorf("got session %d, wanted 2", second.session)
+	}
+	if len(second.entries) != 3 || second.entries[1].Code != "rate(x[5m])" || second.entries[1].Status != "error" ||
+		len(second.entries[2].Code) != maxHistoryCode+len("...") {
+		t.Errorf("got entries %v, wanted the entries of the first session", second.entries)
+	}
+}
+
+func testHistory() *history {
+	h := newHistory()
+	h.entries = []*historyEntry{
+		{Session: 1, ExecutionCount: 1, Code: "@server=http://prometheus:9090"},
+		{Session: 1, ExecutionCount: 2, Code: "up"},
+		{Session: 1, ExecutionCount: 3, Code: "graph(rate(http_requests_total[5m]))"},
+		{Session: 2, ExecutionCount: 1, Code: "up"},
+		{Session: 2, ExecutionCount: 2, Code: "sum(up)"},
+	}
+	h.session = 2
+	return h
+}
+
+func TestHandleHistory(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		req     scaffold.HistoryRequest
+		history []scaffold.HistoryEntry
+	}{
+		{
+			desc: "tail",
+			req:  scaffold.HistoryRequest{HistAccessType: "tail", N: 2},
+			history: []scaffold.HistoryEntry{
+				{Session: 2, Line: 1, Input: "up"},
+				{Session: 2, Line: 2, Input: "sum(up)"},
+			},
+		},
+		{
+			desc: "range of previous session",
+			req:  scaffold.HistoryRequest{HistAccessType: "range", Session: -1, Start: 2},
+			history: []scaffold.HistoryEntry{
+				{Session: 1, Line: 2, Input: "up"},
+				{Session: 1, Line: 3, Input: "graph(rate(http_requests_total[5m]))"},
+			},
+		},
+		{
+			desc: "range with stop",
+			req:  scaffold.HistoryRequest{HistAccessType: "range", Session: 1, Start: 1, Stop: 2},
+			history: []scaffold.HistoryEntry{
+				{Session: 1, Line: 1, Input: "@server=http://prometheus:9090"},
+			},
+		},
+		{
+			desc: "search",
+			req:  scaffold.HistoryRequest{HistAccessType: "search", Pattern: "*up*"},
+			history: []scaffold.HistoryEntry{
+				{Session: 1, Line: 2, Input: "up"},
+				{Session: 2, Line: 1, Input: "up"},
+				{Session: 2, Line: 2, Input: "sum(up)"},
+			},
+		},
+		{
+			desc: "search unique",
+			req:  scaffold.HistoryRequest{HistAccessType: "search", Pattern: "u?", Unique: true},
+			history: []scaffold.HistoryEntry{
+				{Session: 2, Line: 1, Input: "up"},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			k.history = testHistory()
+
+			reply := k.HandleHistory(&test.req)
+			if reply.Status != "ok" {
+				t.Fatalf("got status %s", reply.Status)
+			}
+			if !reflect.DeepEqual(reply.History, test.history) {
+				t.Errorf("got history %v, wanted %v", reply.History, test.history)
+			}
+		})
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	h := testHistory()
+
+	entries := h.filter("RATE", 0)
+	if len(entries) != 1 || entries[0].ExecutionCount != 3 {
+		t.Errorf("got %v, wanted the graph cell", entries)
+	}
+
+	entries = h.filter("", 2)
+	if len(entries) != 2 || entries[1].Code != "sum(up)" {
+		t.Errorf("got %v, wanted the last two cells", entries)
+	}
+}
+
+func TestHistoryConcurrentSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipromnb-history")
+	if err != nil {
+		t.Fatalf("can not create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+
+	histories := make([]*history, 5)
+	var wg sync.WaitGroup
+	for i := range histories {
+		histories[i] = newHistory()
+		wg.Add(1)
+		go func(h *history) {
+			defer wg.Done()
+			if err := h.open(path); err != nil {
+				t.Errorf("got error: %s", err)
+			}
+		}(histories[i])
+	}
+	wg.Wait()
+
+	sessions := map[int]bool{}
+	for _, h := range histories {
+		if sessions[h.session] {
+			t.Errorf("session %d was started twice", h.session)
+		}
+		sessions[h.session] = true
+	}
+}
+
+func TestHistoryEntryJSON(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		entry scaffold.HistoryEntry
+		json  string
+	}{
+		{
+			desc:  "input",
+			entry: scaffold.HistoryEntry{Session: 1, Line: 2, Input: "up", Output: "1"},
+			json:  `[1,2,"up"]`,
+		},
+		{
+			desc:  "output",
+			entry: scaffold.HistoryEntry{Session: 1, Line: 2, Input: "up", IncludeOutput: true, Output: "1"},
+			json:  `[1,2,["up","1"]]`,
+		},
+		{
+			desc:  "no output",
+			entry: scaffold.HistoryEntry{Session: 1, Line: 2, Input: "@start=now-1h", IncludeOutput: true},
+			json:  `[1,2,["@start=now-1h",null]]`,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := json.Marshal(test.entry)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+			if string(data) != test.json {
+				t.Errorf("got %s, wanted %s", data, test.json)
+			}
+		})
+	}
+}
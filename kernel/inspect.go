@@ -0,0 +1,187 @@
+package kernel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// identifierAt returns the identifier the cursor is in or directly behind.
+// The position is counted in characters, not bytes.
+func identifierAt(input string, pos int) string {
+	runes := []rune(input)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+
+	_, start, _ := lastIdentifier(input, pos)
+	end := pos
+	for end < len(runes) && isIdentifierRune(runes[end]) {
+		end++
+	}
+
+	word := string(runes[start:end])
+	if word != "" && !isIdentifierStart([]rune(word)[0]) {
+		// Numbers like 5m
+		return ""
+	}
+	return word
+}
+
+// metricMetadata is the metadata of a metric reported by a target.
+type metricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metricInfo contains everything shown when inspecting a metric.
+type metricInfo struct {
+	Name     string
+	Metadata []metricMetadata
+	Labels   []string
+	Series   int
+	Sample   *model.Sample
+}
+
+// Text renders the information about the metric as plain text.
+func (m *metricInfo) Text() string {
+	output := &bytes.Buffer{}
+	fmt.Fprintln(output, m.Name)
+
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, meta := range m.Metadata {
+		fmt.Fprintf(w, "\nType:\t%s\n", meta.Type)
+		if meta.Unit != "" {
+			fmt.Fprintf(w, "Unit:\t%s\n", meta.Unit)
+		}
+		fmt.Fprintf(w, "Help:\t%s\n", meta.Help)
+	}
+	if len(m.Metadata) == 0 {
+		fmt.Fprintln(w, "\nNo metadata reported by targets.")
+	}
+
+	fmt.Fprintf(w, "\nLabels:\t%s\n", strings.Join(m.Labels, ", "))
+	fmt.Fprintf(w, "Series:\t%d\n", m.Series)
+	if m.Sample != nil {
+		fmt.Fprintf(w, "Sample:\t%s => %s\n", m.Sample.Metric, formatValue(m.Sample.Value))
+	}
+	w.Flush()
+
+	return output.String()
+}
+
+// functionHelp returns the signature and documentation of a function or aggregation.
+// The second return value is false if name is neither.
+func functionHelp(name string) (string, bool) {
+	if f, ok := promql.Functions[name]; ok {
+		return fmt.Sprintf("%s\n\n%s", f.Signature(), promql.FunctionDocs[name]), true
+	}
+
+	if containsString(promql.Aggregations, name) {
+		return fmt.Sprintf("%s\n\n%s", promql.AggregationSignature(name), promql.AggregationDocs[name]), true
+	}
+
+	return "", false
+}
+
+func (k *Kernel) handleInspect(code string, cursorPos int) (map[string]interface{}, error) {
+	word := identifierAt(code, cursorPos)
+	if word == "" || containsString(promql.Keywords, word) {
+		return nil, nil
+	}
+
+	if usage, ok := commandUsage[word]; ok {
+		if cmd, _ := parseCommand(code); cmd != nil && cmd.Name == word {
+			return map[string]interface{}{
+				"text/plain": usage,
+			}, nil
+		}
+	}
+
+	if help, ok := functionHelp(word); ok {
+		return map[string]interface{}{
+			"text/plain": help,
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	info, err := k.inspectMetric(ctx, word)
+	if err != nil || info == nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"text/plain": info.Text(),
+	}, nil
+}
+
+// inspectMetric collects information about a metric from the server.
+// It returns nil if the server does not know the metric.
+func (k *Kernel) inspectMetric(ctx context.Context, name string) (*metricInfo, error) {
+	client, err := k.getClient()
+	if err != nil {
+		return nil, err
+	}
+	api := promv1.NewAPI(client)
+
+	// The metadata endpoint is not available on older servers and some proxies, so HELP and TYPE are optional.
+	var targets []metricMetadata
+	if err := apiGet(ctx, client, "/api/v1/targets/metadata", url.Values{"metric": {name}}, &targets); err != nil {
+		log.Printf("Error getting metadata of %s: %s", name, err)
+	}
+
+	info := &metricInfo{
+		Name: name,
+	}
+	seen := map[metricMetadata]bool{}
+	for _, meta := range targets {
+		if !seen[meta] {
+			seen[meta] = true
+			info.Metadata = append(info.Metadata, meta)
+		}
+	}
+
+	selector := k.scopeSelectors([]string{name})[0]
+	count, err := api.Query(ctx, fmt.Sprintf("count(%s)", selector), k.Options.TimeEnd)
+	if err != nil {
+		return nil, err
+	}
+	if vector, ok := count.(model.Vector); ok && len(vector) > 0 {
+		info.Series = int(vector[0].Value)
+	}
+
+	if info.Series == 0 && len(info.Metadata) == 0 {
+		return nil, nil
+	}
+
+	if info.Series > 0 {
+		sample, err := api.Query(ctx, fmt.Sprintf("topk(1, %s)", selector), k.Options.TimeEnd)
+		if err != nil {
+			return nil, err
+		}
+		if vector, ok := sample.(model.Vector); ok && len(vector) > 0 {
+			info.Sample = vector[0]
+		}
+	}
+
+	info.Labels, err = k.labelNames(ctx, []string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,157 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIdentifierAt(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		pos   int
+		out   string
+	}{
+		{
+			desc:  "empty",
+			input: "",
+			pos:   0,
+			out:   "",
+		},
+		{
+			desc:  "end",
+			input: "rate(up[5m])",
+			pos:   4,
+			out:   "rate",
+		},
+		{
+			desc:  "middle",
+			input: "rate(up[5m])",
+			pos:   2,
+			out:   "rate",
+		},
+		{
+			desc:  "start",
+			input: "sum(http_requests_total)",
+			pos:   4,
+			out:   "http_requests_total",
+		},
+		{
+			desc:  "duration",
+			input: "rate(up[5m])",
+			pos:   9,
+			out:   "",
+		},
+		{
+			desc:  "operator",
+			input: "a + b",
+			pos:   3,
+			out:   "",
+		},
+		{
+			desc:  "position too large",
+			input: "up",
+			pos:   10,
+			out:   "up",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			out := identifierAt(test.input, test.pos)
+			if out != test.out {
+				t.Errorf("got %q, wanted %q", out, test.out)
+			}
+		})
+	}
+}
+
+func TestFunctionHelp(t *testing.T) {
+	for _, test := range []struct {
+		desc      string
+		name      string
+		found     bool
+		signature string
+	}{
+		{
+			desc:      "function",
+			name:      "rate",
+			found:     true,
+			signature: "rate(range-vector) instant-vector",
+		},
+		{
+			desc:      "optional argument",
+			name:      "round",
+			found:     true,
+			signature: "round(instant-vector, scalar?) instant-vector",
+		},
+		{
+			desc:      "aggregation",
+			name:      "topk",
+			found:     true,
+			signature: "topk [by|without (<label list>)] (scalar, instant-vector) instant-vector",
+		},
+		{
+			desc:  "metric",
+			name:  "up",
+			found: false,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			help, found := functionHelp(test.name)
+			if found != test.found {
+				t.Fatalf("got found %v, wanted %v", found, test.found)
+			}
+
+			if !strings.HasPrefix(help, test.signature) {
+				t.Errorf("got %q, wanted signature %q", help, test.signature)
+			}
+		})
+	}
+}
+
+func TestInspectMetricWithoutMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			value := "2"
+			if strings.HasPrefix(r.FormValue("query"), "topk") {
+				value = "1"
+			}
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"node"},"value":[0,"%s"]}]}}`, value)
+		case "/api/v1/labels":
+			fmt.Fprint(w, `{"status":"success","data":["__name__","job"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	k := New(server.URL)
+	info, err := k.inspectMetric(context.Background(), "up")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if info == nil {
+		t.Fatal("got no info")
+	}
+	if len(info.Metadata) != 0 {
+		t.Errorf("got metadata %v, wanted none", info.Metadata)
+	}
+	if info.Series != 2 {
+		t.Errorf("got %d series, wanted 2", info.Series)
+	}
+	if !reflect.DeepEqual(info.Labels, []string{"job"}) {
+		t.Errorf("got labels %v, wanted [job]", info.Labels)
+	}
+}
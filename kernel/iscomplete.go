@@ -0,0 +1,117 @@
+package kernel
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Statuses of is_complete_reply.
+const (
+	statusComplete   = "complete"
+	statusIncomplete = "incomplete"
+	statusInvalid    = "invalid"
+)
+
+// indentUnit is used for indenting continuation lines, once per open bracket.
+const indentUnit = "  "
+
+// danglingTokens contains the tokens which need another expression following them.
+var danglingTokens = []string{
+	"+", "-", "*", "/", "%", "^",
+	"==", "!=", "<", ">", "<=", ">=",
+	"and", "or", "unless", "bool", "offset",
+}
+
+var closingBrackets = map[rune]rune{
+	')': '(',
+	'}': '{',
+	']': '[',
+}
+
+// checkComplete checks whether the code of a cell can be executed or needs more lines.
+// If the code is incomplete an indentation for the next line is returned.
+func checkComplete(code string) (status, indent string) {
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" || strings.HasPrefix(trimmed, "@") {
+		return statusComplete, ""
+	}
+
+	var (
+		stack []rune
+		last  string
+	)
+	runes := []rune(code)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+		case r == '#':
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case r == '"' || r == '\'' || r == '`':
+			end := -1
+			for j := i + 1; j < len(runes); j++ {
+				if runes[j] == '\\' && r != '`' {
+					j++
+					continue
+				}
+				if runes[j] == '\n' && r != '`' {
+					// Only raw strings can span multiple lines
+					return statusInvalid, ""
+				}
+				if runes[j] == r {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				if r != '`' {
+					// The next line can not close the string
+					return statusInvalid, ""
+				}
+				return statusIncomplete, strings.Repeat(indentUnit, len(stack))
+			}
+			i = end
+			last = "string"
+		case r == '(' || r == '{' || r == '[':
+			stack = append(stack, r)
+			last = string(r)
+		case closingBrackets[r] != 0:
+			if len(stack) == 0 || stack[len(stack)-1] != closingBrackets[r] {
+				return statusInvalid, ""
+			}
+			stack = stack[:len(stack)-1]
+			last = string(r)
+		case isIdentifierStart(r):
+			start := i
+			for i+1 < len(runes) && isIdentifierRune(runes[i+1]) {
+				i++
+			}
+			last = strings.ToLower(string(runes[start : i+1]))
+		case unicode.IsDigit(r) || r == '.':
+			for i+1 < len(runes) && (isIdentifierRune(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			last = "number"
+		case strings.ContainsRune("+-*/%^=!<>~", r):
+			start := i
+			for i+1 < len(runes) && strings.ContainsRune("=~", runes[i+1]) {
+				i++
+			}
+			last = string(runes[start : i+1])
+		default:
+			last = string(r)
+		}
+	}
+
+	if len(stack) > 0 {
+		return statusIncomplete, strings.Repeat(indentUnit, len(stack))
+	}
+
+	if containsString(danglingTokens, last) {
+		return statusIncomplete, indentUnit
+	}
+
+	return statusComplete, ""
+}
@@ -0,0 +1,117 @@
+package kernel
+
+import "testing"
+
+func TestCheckComplete(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		code   string
+		status string
+		indent string
+	}{
+		{
+			desc:   "empty",
+			code:   "",
+			status: statusComplete,
+		},
+		{
+			desc:   "simple",
+			code:   "up",
+			status: statusComplete,
+		},
+		{
+			desc:   "option",
+			code:   "@start=now-1h",
+			status: statusComplete,
+		},
+		{
+			desc:   "open bracket",
+			code:   "sum by (job) (",
+			status: statusIncomplete,
+			indent: "  ",
+		},
+		{
+			desc:   "nested brackets",
+			code:   "sum(\n  rate(\n    http_requests_total{",
+			status: statusIncomplete,
+			indent: "      ",
+		},
+		{
+			desc:   "multi-line complete",
+			code:   "sum by (job) (\n  rate(http_requests_total[5m])\n)",
+			status: statusComplete,
+		},
+		{
+			desc:   "dangling operator",
+			code:   "sum(rate(a[5m])) /",
+			status: statusIncomplete,
+			indent: "  ",
+		},
+		{
+			desc:   "dangling keyword",
+			code:   "up == 0 and",
+			status: statusIncomplete,
+			indent: "  ",
+		},
+		{
+			desc:   "dangling operator in comment",
+			code:   "up # up /",
+			status: statusComplete,
+		},
+		{
+			desc:   "bracket in string",
+			code:   `up{job="("}`,
+			status: statusComplete,
+		},
+		{
+			desc:   "open string",
+			code:   `up{job="abc`,
+			status: statusInvalid,
+		},
+		{
+			desc:   "open single-quoted string",
+			code:   `up{job='abc`,
+			status: statusInvalid,
+		},
+		{
+			desc:   "open raw string",
+			code:   "up{job=~`abc\n",
+			status: statusIncomplete,
+			indent: "  ",
+		},
+		{
+			desc:   "newline in string",
+			code:   "up{job=\"abc\ndef\"}",
+			status: statusInvalid,
+		},
+		{
+			desc:   "mismatched bracket",
+			code:   "sum(up]",
+			status: statusInvalid,
+		},
+		{
+			desc:   "too many closing brackets",
+			code:   "sum(up))",
+			status: statusInvalid,
+		},
+		{
+			desc:   "matcher is not dangling",
+			code:   "up{job!=\"a\"}",
+			status: statusComplete,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			status, indent := checkComplete(test.code)
+			if status != test.status {
+				t.Errorf("got status %q, wanted %q", status, test.status)
+			}
+
+			if indent != test.indent {
+				t.Errorf("got indent %q, wanted %q", indent, test.indent)
+			}
+		})
+	}
+}
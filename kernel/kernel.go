@@ -5,34 +5,52 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xperimental/ipromnb/scaffold"
 )
 
 type Kernel struct {
-	Options   Options
-	client    *http.Client
-	execution int
-	queries   []string
+	Options Options
+	client  *http.Client
+	history *history
+	// comms is used for the widgets, it is nil if the frontend does not support comms.
+	comms *scaffold.CommManager
+	// followGraphs are updated when the time range is changed using the widget.
+	followGraphs []*followGraph
+	// watches are the graphs refreshed in the background.
+	watches    map[*watch]bool
+	watchMutex sync.Mutex
+
+	mutex           sync.Mutex
+	scrapeIntervals map[string]scrapeIntervalEntry
+	metadata        *metadataCache
 }
 
 // New creates a new Prometheus kernel.
 func New(server string) *Kernel {
 	return &Kernel{
 		Options: Options{
-			Server:    server,
-			TimeStart: time.Now().Add(-24 * time.Hour),
-			TimeEnd:   time.Now(),
-			NowFunc:   time.Now,
+			Server:          server,
+			TimeStart:       time.Now().Add(-24 * time.Hour),
+			TimeEnd:         time.Now(),
+			Location:        time.UTC,
+			Resolution:      defaultResolution,
+			Unit:            unitAuto,
+			CacheTTL:        defaultCacheTTL,
+			CompletionLimit: defaultCompletionLimit,
+			Lint:            lintWarn,
+			NowFunc:         time.Now,
 		},
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		execution: 0,
-		queries:   []string{},
+		history:         newHistory(),
+		watches:         map[*watch]bool{},
+		scrapeIntervals: map[string]scrapeIntervalEntry{},
+		metadata:        newMetadataCache(),
 	}
 }
 
@@ -42,7 +60,11 @@ func (k *Kernel) HandleKernelInfo() scaffold.KernelInfo {
 		Implementation:        "ipromnb",
 		ImplementationVersion: "0.0.1",
 		LanguageInfo: scaffold.KernelLanguageInfo{
-			Name: "prometheus",
+			Name:           "prometheus",
+			Mimetype:       "text/x-promql",
+			FileExtension:  ".promql",
+			CodemirrorMode: "promql",
+			PygmentsLexer:  "promql",
 		},
 		Banner: "prometheus banner",
 		HelpLinks: []scaffold.HelpLink{
@@ -62,53 +84,116 @@ func (k *Kernel) HandleKernelInfo() scaffold.KernelInfo {
 	}
 }
 
-var graphRegex = regexp.MustCompile(`^graph(0?)\((.+)\)$`)
-
 func (k *Kernel) HandleExecuteRequest(ctx context.Context, req *scaffold.ExecuteRequest,
-	stream func(name, text string), displayData scaffold.DisplayFunc) *scaffold.ExecuteResult {
+	stream func(name, text string), displayData scaffold.DisplayFunc, readInput scaffold.InputFunc) *scaffold.ExecuteResult {
+
+	started := time.Now()
+	query, result := k.execute(ctx, req, stream, displayData, readInput)
+
+	if req.StoreHistory && !req.Silent {
+		k.history.add(&historyEntry{
+			ExecutionCount: req.ExecutionCount,
+			Code:           req.Code,
+			Query:          query,
+			Server:         k.Options.Server,
+			Start:          k.Options.TimeStart,
+			End:            k.Options.TimeEnd,
+			Time:           started,
+			Duration:       time.Since(started).Seconds(),
+			Status:         result.Status,
+			Output:         resultText(result),
+		})
+	}
+
+	return result
+}
 
-	k.execution++
+// execute executes a cell and returns the executed query, if the cell contained one.
+func (k *Kernel) execute(ctx context.Context, req *scaffold.ExecuteRequest,
+	stream func(name, text string), displayData scaffold.DisplayFunc, readInput scaffold.InputFunc) (string, *scaffold.ExecuteResult) {
+
+	// Executing a cell again stops its watch.
+	k.stopWatches(func(w *watch) bool {
+		return w.inCell(req.CellID, req.Code)
+	})
+
+	if strings.TrimSpace(req.Code) == "@rangewidget" {
+		return "", &scaffold.ExecuteResult{
+			Status: "ok",
+			Result: k.handleRangeWidget(),
+		}
+	}
 
 	if strings.HasPrefix(req.Code, "@") {
-		if err := k.handleOptions(req.Code); err != nil {
-			stream("stderr", fmt.Sprintf("Error setting options: %s", err))
-			return &scaffold.ExecuteResult{
-				Status: "error",
-			}
+		if err := k.handleOptions(req.Code, readInput); err != nil {
+			return "", errorResult("OptionError", fmt.Sprintf("Error setting options: %s", err))
 		}
 
-		stream("stdout", k.Options.Pretty())
-		return &scaffold.ExecuteResult{
-			Status:         "ok",
-			ExecutionCount: k.execution,
+		return "", &scaffold.ExecuteResult{
+			Status: "ok",
+			Result: &scaffold.DisplayData{
+				Data: map[string]interface{}{
+					"text/plain": k.Options.Pretty(),
+				},
+			},
 		}
 	}
 
-	query, err := k.handleQuery(ctx, k.execution, req.Code, stream, displayData)
-	k.queries = append(k.queries, query)
+	var payload []scaffold.Payload
+	setNextInput := func(text string) {
+		payload = append(payload, scaffold.Payload{
+			"source":  "set_next_input",
+			"text":    text,
+			"replace": true,
+		})
+	}
 
-	if err != nil {
-		stream("stderr", fmt.Sprintf("Error executing query: %s", err))
-		return &scaffold.ExecuteResult{
-			Status: "error",
+	query, result, err := k.handleQuery(ctx, req.Code, req.CellID, stream, displayData, setNextInput)
+
+	if syntaxErr, ok := err.(*syntaxError); ok {
+		return query, &scaffold.ExecuteResult{
+			Status:    "error",
+			Ename:     "SyntaxError",
+			Evalue:    syntaxErr.Error(),
+			Traceback: syntaxErr.Traceback(),
 		}
 	}
 
-	return &scaffold.ExecuteResult{
-		Status:         "ok",
-		ExecutionCount: k.execution,
+	if err != nil && ctx.Err() == context.Canceled {
+		return query, errorResult("KeyboardInterrupt", "Query was interrupted")
 	}
-}
 
-func lastIdentifier(input string, pos int) (string, int, int) {
-	input = input[:pos]
-	index := strings.LastIndexAny(input, ` +-*/{}[]()`)
+	if err != nil {
+		return query, errorResult("QueryError", fmt.Sprintf("Error executing query: %s", err))
+	}
 
-	if index == -1 {
-		return input, 0, len(input)
+	return query, &scaffold.ExecuteResult{
+		Status:  "ok",
+		Payload: payload,
+		Result:  result,
 	}
+}
 
-	return input[index+1:], index + 1, len(input)
+// resultText returns the plain text of the result of an execution, or its error.
+func resultText(result *scaffold.ExecuteResult) string {
+	if result.Status == "error" {
+		return result.Ename + ": " + result.Evalue
+	}
+	if result.Result == nil {
+		return ""
+	}
+	text, _ := result.Result.Data["text/plain"].(string)
+	return text
+}
+
+// errorResult returns a failed execution with the message as its traceback.
+func errorResult(name, message string) *scaffold.ExecuteResult {
+	return &scaffold.ExecuteResult{
+		Status:    "error",
+		Ename:     name,
+		Evalue:    message,
+		Traceback: []string{message},
+	}
 }
 
 func (k *Kernel) HandleComplete(req *scaffold.CompleteRequest) *scaffold.CompleteReply {
@@ -129,12 +214,25 @@ func (k *Kernel) HandleComplete(req *scaffold.CompleteRequest) *scaffold.Complet
 }
 
 func (k *Kernel) HandleInspect(req *scaffold.InspectRequest) *scaffold.InspectReply {
+	data, err := k.handleInspect(req.Code, req.CursorPos)
+	if err != nil {
+		log.Printf("Error executing inspection: %s", err)
+		return &scaffold.InspectReply{
+			Status: "error",
+		}
+	}
+
 	return &scaffold.InspectReply{
 		Status: "ok",
-		Found:  false,
+		Found:  data != nil,
+		Data:   data,
 	}
 }
 
 func (k *Kernel) HandleIsComplete(req *scaffold.IsCompleteRequest) *scaffold.IsCompleteReply {
-	return nil
+	status, indent := checkComplete(req.Code)
+	return &scaffold.IsCompleteReply{
+		Status: status,
+		Indent: indent,
+	}
 }
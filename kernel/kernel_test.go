@@ -1,6 +1,12 @@
 package kernel
 
-import "testing"
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/xperimental/ipromnb/promql"
+)
 
 func TestLastIdentifier(t *testing.T) {
 	for _, test := range []struct {
@@ -72,3 +78,21 @@ func TestLastIdentifier(t *testing.T) {
 		})
 	}
 }
+
+func TestKernelJSFunctions(t *testing.T) {
+	source, err := ioutil.ReadFile("../kernelspec/kernel.js")
+	if err != nil {
+		t.Fatalf("error reading kernel.js: %s", err)
+	}
+
+	words := append(promql.FunctionNames(), promql.Aggregations...)
+	for name := range commandArgs {
+		words = append(words, name)
+	}
+
+	for _, word := range words {
+		if !strings.Contains(string(source), `"`+word+`"`) {
+			t.Errorf("kernel.js does not highlight %q", word)
+		}
+	}
+}
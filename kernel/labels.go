@@ -0,0 +1,187 @@
+package kernel
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// metadataSource fetches metadata from a server. The options are copied when it is created,
+// so that it can safely be used in the background.
+type metadataSource struct {
+	client api.Client
+	api    promv1.API
+	start  time.Time
+	end    time.Time
+}
+
+func (k *Kernel) newMetadataSource() (*metadataSource, error) {
+	client, err := k.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadataSource{
+		client: client,
+		api:    promv1.NewAPI(client),
+		start:  k.Options.TimeStart,
+		end:    k.Options.TimeEnd,
+	}, nil
+}
+
+// metricNames returns the names of all metrics known by the server.
+func (s *metadataSource) metricNames(ctx context.Context) ([]string, error) {
+	return s.labelValues(ctx, model.MetricNameLabel, nil)
+}
+
+// labelNames returns the label names of the series matching one of the selectors.
+// If no selectors are given, all label names known by the server are returned.
+func (s *metadataSource) labelNames(ctx context.Context, selectors []string) ([]string, error) {
+	var names []string
+	if err := apiGet(ctx, s.client, "/api/v1/labels", s.matchParams(selectors), &names); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != model.MetricNameLabel || len(selectors) == 0 {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// labelValues returns the values of a label of the series matching one of the selectors.
+// If no selectors are given, all values known by the server are returned.
+func (s *metadataSource) labelValues(ctx context.Context, label string, selectors []string) ([]string, error) {
+	if len(selectors) == 0 {
+		values, err := s.api.LabelValues(ctx, label)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]string, len(values))
+		for i, v := range values {
+			result[i] = string(v)
+		}
+		return result, nil
+	}
+
+	var values []string
+	endpoint := "/api/v1/label/" + url.PathEscape(label) + "/values"
+	if err := apiGet(ctx, s.client, endpoint, s.matchParams(selectors), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// matchParams returns the parameters restricting a metadata request to the series matching the selectors
+// in the time range of the source. The server only looks at the index, so no series are transferred.
+func (s *metadataSource) matchParams(selectors []string) url.Values {
+	params := url.Values{}
+	if len(selectors) == 0 {
+		return params
+	}
+
+	params["match[]"] = selectors
+	params.Set("start", strconv.FormatInt(s.start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(s.end.Unix(), 10))
+	return params
+}
+
+// metricNames returns the cached names of all metrics known by the server.
+func (k *Kernel) metricNames(ctx context.Context) ([]string, error) {
+	source, err := k.newMetadataSource()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.Options.Matchers) > 0 {
+		selectors := k.scopeSelectors(nil)
+		return k.metadata.get(ctx, k.Options.Server, "metrics:"+selectors[0], k.Options.CacheTTL, func(ctx context.Context) ([]string, error) {
+			return source.labelValues(ctx, model.MetricNameLabel, selectors)
+		})
+	}
+
+	return k.metadata.get(ctx, k.Options.Server, "metrics", k.Options.CacheTTL, source.metricNames)
+}
+
+// scopeSelectors adds the matchers set using @matchers to the selectors.
+// If no selectors are given, a selector consisting only of the matchers is returned.
+func (k *Kernel) scopeSelectors(selectors []string) []string {
+	if len(k.Options.Matchers) == 0 {
+		return selectors
+	}
+
+	if len(selectors) == 0 {
+		return []string{promql.MatchersString(k.Options.Matchers)}
+	}
+
+	scoped := make([]string, len(selectors))
+	for i, selector := range selectors {
+		scoped[i] = selector
+		if expr, err := promql.ParseExpr(selector); err == nil {
+			promql.InjectMatchers(expr, k.Options.Matchers)
+			scoped[i] = promql.String(expr)
+		}
+	}
+	return scoped
+}
+
+// labelNames returns the cached label names of the series matching one of the selectors.
+func (k *Kernel) labelNames(ctx context.Context, selectors []string) ([]string, error) {
+	source, err := k.newMetadataSource()
+	if err != nil {
+		return nil, err
+	}
+	selectors = k.scopeSelectors(selectors)
+
+	key := "labels:" + strings.Join(selectors, ",")
+	return k.metadata.get(ctx, k.Options.Server, key, k.Options.CacheTTL, func(ctx context.Context) ([]string, error) {
+		return source.labelNames(ctx, selectors)
+	})
+}
+
+// labelValues returns the cached values of a label of the series matching one of the selectors.
+func (k *Kernel) labelValues(ctx context.Context, label string, selectors []string) ([]string, error) {
+	source, err := k.newMetadataSource()
+	if err != nil {
+		return nil, err
+	}
+	selectors = k.scopeSelectors(selectors)
+
+	key := "values:" + label + ":" + strings.Join(selectors, ",")
+	return k.metadata.get(ctx, k.Options.Server, key, k.Options.CacheTTL, func(ctx context.Context) ([]string, error) {
+		return source.labelValues(ctx, label, selectors)
+	})
+}
+
+// prefetchMetadata fetches the metric and label names of the current server in the background.
+func (k *Kernel) prefetchMetadata() {
+	source, err := k.newMetadataSource()
+	if err != nil {
+		return
+	}
+
+	k.metadata.prefetch(k.Options.Server, "metrics", source.metricNames)
+	k.metadata.prefetch(k.Options.Server, "labels:", func(ctx context.Context) ([]string, error) {
+		return source.labelNames(ctx, nil)
+	})
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xperimental/ipromnb/promql"
+)
+
+func TestMetadataWithMatchers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if match := r.FormValue("match[]"); match != `{cluster="eu-1"}` && match != `up{cluster="eu-1"}` {
+			t.Errorf("got match %q on %s", match, r.URL.Path)
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+		case "/api/v1/label/job/values":
+			fmt.Fprint(w, `{"status":"success","data":["node"]}`)
+		case "/api/v1/labels":
+			fmt.Fprint(w, `{"status":"success","data":["__name__","cluster","job"]}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	k := New(server.URL)
+	k.Options.Matchers = []*promql.LabelMatcher{{Name: "cluster", Type: promql.MatchEqual, Value: "eu-1"}}
+	ctx := context.Background()
+
+	metrics, err := k.metricNames(ctx)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !reflect.DeepEqual(metrics, []string{"up"}) {
+		t.Errorf("got metrics %v", metrics)
+	}
+
+	labels, err := k.labelNames(ctx, []string{"up"})
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !reflect.DeepEqual(labels, []string{"cluster", "job"}) {
+		t.Errorf("got labels %v", labels)
+	}
+
+	values, err := k.labelValues(ctx, "job", []string{"up"})
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !reflect.DeepEqual(values, []string{"node"}) {
+		t.Errorf("got values %v", values)
+	}
+}
@@ -0,0 +1,236 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// Levels of the lint option.
+const (
+	lintOff   = "off"
+	lintWarn  = "warn"
+	lintError = "error"
+)
+
+var lintLevels = []string{lintOff, lintWarn, lintError}
+
+var (
+	// counterFunctions only work correctly on counters.
+	counterFunctions = []string{"rate", "irate", "increase", "resets"}
+	counterSuffixes  = []string{"_total", "_count", "_sum", "_bucket"}
+)
+
+// linter checks queries for common mistakes. The functions provide information from the server,
+// they return zero values if the information is not available.
+type linter struct {
+	// metricType returns the type of a metric, for example "counter".
+	metricType func(name string) string
+	// seriesCount returns the number of series matching a selector.
+	seriesCount func(selector string) int
+	// maxSeries is the number of series above which selectors should be aggregated.
+	// Zero disables the check, which needs a query for every selector.
+	maxSeries      int
+	scrapeInterval time.Duration
+}
+
+// lint returns the warnings for an expression.
+func (l *linter) lint(expr promql.Expr) []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	promql.Walk(expr, func(e promql.Expr) {
+		switch e := e.(type) {
+		case *promql.Call:
+			l.lintCall(e, warn)
+		case *promql.VectorSelector:
+			for _, m := range e.Matchers {
+				if (m.Type == promql.MatchRegexp || m.Type == promql.MatchNotRegexp) && regexp.QuoteMeta(m.Value) == m.Value {
+					op := promql.MatchEqual
+					if m.Type == promql.MatchNotRegexp {
+						op = promql.MatchNotEqual
+					}
+					fixed := &promql.LabelMatcher{Name: m.Name, Type: op, Value: m.Value}
+					warn("regular expression %s does not contain special characters, use %s instead",
+						promql.MatchersString([]*promql.LabelMatcher{m}), promql.MatchersString([]*promql.LabelMatcher{fixed}))
+				}
+			}
+		}
+	})
+
+	if l.maxSeries <= 0 {
+		return warnings
+	}
+
+	for _, sel := range unaggregatedSelectors(expr, false) {
+		query := promql.String(sel)
+		if count := l.seriesCount(query); count > l.maxSeries {
+			warn("%s selects %d series, consider aggregating it, for example sum by (job) (...)", query, count)
+		}
+	}
+
+	return warnings
+}
+
+func (l *linter) lintCall(call *promql.Call, warn func(format string, args ...interface{})) {
+	name := call.Func.Name
+	switch {
+	case containsString(counterFunctions, name) && len(call.Args) == 1:
+		switch arg := unwrapParens(call.Args[0]).(type) {
+		case *promql.MatrixSelector:
+			metric := arg.Vector.Name
+			switch typ := l.metricType(metric); {
+			case typ != "" && typ != "counter" && typ != "unknown":
+				warn("%s should only be used with counters, but %s is a %s", name, metric, typ)
+			case typ == "" && metric != "" && !hasCounterSuffix(metric):
+				warn("%s should only be used with counters, but %s does not look like a counter", name, metric)
+			}
+
+			if l.scrapeInterval > 0 && arg.Range < 2*l.scrapeInterval && name != "resets" {
+				warn("the range of %s is shorter than twice the scrape interval (%s), use at least [%s]",
+					promql.String(arg), promql.FormatDuration(l.scrapeInterval), promql.FormatDuration(2*l.scrapeInterval))
+			}
+		case *promql.SubqueryExpr:
+			if agg, ok := unwrapParens(arg.Expr).(*promql.AggregateExpr); ok && agg.Op == "sum" {
+				warn("%s of a sum is not correct when counters reset, use sum(%s(...)) instead", name, name)
+			}
+		}
+	case name == "histogram_quantile" && len(call.Args) == 2:
+		agg, ok := unwrapParens(call.Args[1]).(*promql.AggregateExpr)
+		if !ok {
+			return
+		}
+
+		keepsLe := containsString(agg.Grouping, "le")
+		if agg.Without {
+			keepsLe = !keepsLe
+		}
+		if !keepsLe {
+			warn("histogram_quantile needs the \"le\" label, add it to the aggregation, for example %s by (le) (...)", agg.Op)
+		}
+	}
+}
+
+// unaggregatedSelectors returns the vector selectors which are not part of an aggregation.
+func unaggregatedSelectors(expr promql.Expr, aggregated bool) []*promql.VectorSelector {
+	switch e := expr.(type) {
+	case *promql.AggregateExpr:
+		aggregated = true
+	case *promql.VectorSelector:
+		if !aggregated {
+			return []*promql.VectorSelector{e}
+		}
+		return nil
+	case *promql.Call:
+		if e.Func.Name == "absent" || e.Func.Name == "absent_over_time" {
+			return nil
+		}
+	}
+
+	var result []*promql.VectorSelector
+	for _, child := range promql.Children(expr) {
+		result = append(result, unaggregatedSelectors(child, aggregated)...)
+	}
+	return result
+}
+
+func unwrapParens(expr promql.Expr) promql.Expr {
+	for {
+		paren, ok := expr.(*promql.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.Expr
+	}
+}
+
+func hasCounterSuffix(name string) bool {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setLint(v *string, value string) error {
+	level := strings.ToLower(value)
+	if !containsString(lintLevels, level) {
+		return fmt.Errorf("not a valid lint level: %s (valid levels: %s)", value, strings.Join(lintLevels, ", "))
+	}
+
+	*v = level
+	return nil
+}
+
+// lintQuery prints the lint warnings of a query. If the lint level is "error", warnings cause an error.
+func (k *Kernel) lintQuery(ctx context.Context, expr promql.Expr, stream func(name, text string)) error {
+	if k.Options.Lint == lintOff {
+		return nil
+	}
+
+	api, err := k.getAPI()
+	if err != nil {
+		return err
+	}
+	client, err := k.getClient()
+	if err != nil {
+		return err
+	}
+
+	l := &linter{
+		metricType: func(name string) string {
+			types, err := k.metadata.get(ctx, k.Options.Server, "type:"+name, k.Options.CacheTTL, func(ctx context.Context) ([]string, error) {
+				// Missing metadata is cached as well, so that it is not requested on every execution.
+				var targets []metricMetadata
+				if err := apiGet(ctx, client, "/api/v1/targets/metadata", url.Values{"metric": {name}}, &targets); err != nil {
+					if ctx.Err() != nil {
+						return nil, err
+					}
+					return []string{}, nil
+				}
+
+				set := map[string]bool{}
+				for _, meta := range targets {
+					set[meta.Type] = true
+				}
+				return sortedKeys(set), nil
+			})
+			if err != nil || len(types) != 1 {
+				return ""
+			}
+			return types[0]
+		},
+		seriesCount: func(selector string) int {
+			value, err := api.Query(ctx, fmt.Sprintf("count(%s)", selector), k.Options.TimeEnd)
+			if err != nil {
+				return 0
+			}
+
+			if vector, ok := value.(model.Vector); ok && len(vector) > 0 {
+				return int(vector[0].Value)
+			}
+			return 0
+		},
+		maxSeries:      k.Options.LintSeries,
+		scrapeInterval: k.scrapeInterval(ctx, api),
+	}
+
+	warnings := l.lint(expr)
+	for _, w := range warnings {
+		stream("stderr", "Warning: "+w+"\n")
+	}
+
+	if k.Options.Lint == lintError && len(warnings) > 0 {
+		return fmt.Errorf("query has %d warning(s), use @lint=warn to execute it anyway", len(warnings))
+	}
+	return nil
+}
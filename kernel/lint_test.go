@@ -0,0 +1,134 @@
+package kernel
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xperimental/ipromnb/promql"
+)
+
+func TestLint(t *testing.T) {
+	types := map[string]string{
+		"node_memory_free_bytes": "gauge",
+		"http_requests_total":    "counter",
+	}
+	series := map[string]int{
+		"node_cpu_seconds_total": 5000,
+	}
+
+	l := &linter{
+		metricType: func(name string) string {
+			return types[name]
+		},
+		seriesCount: func(selector string) int {
+			return series[selector]
+		},
+		maxSeries:      1000,
+		scrapeInterval: time.Minute,
+	}
+
+	for _, test := range []struct {
+		desc     string
+		query    string
+		warnings []string
+	}{
+		{
+			desc:  "correct query",
+			query: `sum by (job) (rate(http_requests_total{code="200"}[5m]))`,
+		},
+		{
+			desc:  "rate of gauge",
+			query: "sum(rate(node_memory_free_bytes[5m]))",
+			warnings: []string{
+				"rate should only be used with counters, but node_memory_free_bytes is a gauge",
+			},
+		},
+		{
+			desc:  "rate without metadata",
+			query: "sum(increase(queue_length[5m]))",
+			warnings: []string{
+				"increase should only be used with counters, but queue_length does not look like a counter",
+			},
+		},
+		{
+			desc:  "short range",
+			query: "sum(rate(http_requests_total[1m]))",
+			warnings: []string{
+				"the range of http_requests_total[1m] is shorter than twice the scrape interval (1m), use at least [2m]",
+			},
+		},
+		{
+			desc:  "rate of sum",
+			query: "rate(sum(http_requests_total)[5m:])",
+			warnings: []string{
+				"rate of a sum is not correct when counters reset, use sum(rate(...)) instead",
+			},
+		},
+		{
+			desc:  "histogram_quantile without le",
+			query: "histogram_quantile(0.9, sum by (job) (rate(x_bucket[5m])))",
+			warnings: []string{
+				`histogram_quantile needs the "le" label, add it to the aggregation, for example sum by (le) (...)`,
+			},
+		},
+		{
+			desc:  "histogram_quantile without removing le",
+			query: "histogram_quantile(0.9, sum without (le) (rate(x_bucket[5m])))",
+			warnings: []string{
+				`histogram_quantile needs the "le" label, add it to the aggregation, for example sum by (le) (...)`,
+			},
+		},
+		{
+			desc:  "regex without special characters",
+			query: `sum(up{job=~"api", env!~"dev"})`,
+			warnings: []string{
+				`regular expression {job=~"api"} does not contain special characters, use {job="api"} instead`,
+				`regular expression {env!~"dev"} does not contain special characters, use {env!="dev"} instead`,
+			},
+		},
+		{
+			desc:  "high cardinality",
+			query: "rate(node_cpu_seconds_total[5m])",
+			warnings: []string{
+				"node_cpu_seconds_total selects 5000 series, consider aggregating it, for example sum by (job) (...)",
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			expr, err := promql.ParseExpr(test.query)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			warnings := l.lint(expr)
+			if !reflect.DeepEqual(warnings, test.warnings) {
+				t.Errorf("got %q, wanted %q", warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestLintSeriesDisabled(t *testing.T) {
+	l := &linter{
+		metricType: func(name string) string {
+			return ""
+		},
+		seriesCount: func(selector string) int {
+			t.Errorf("series of %s counted although the check is disabled", selector)
+			return 0
+		},
+	}
+
+	expr, err := promql.ParseExpr("node_cpu_seconds_total")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if warnings := l.lint(expr); len(warnings) != 0 {
+		t.Errorf("got warnings %v", warnings)
+	}
+}
@@ -3,38 +3,108 @@ package kernel
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
+	"github.com/xperimental/ipromnb/scaffold"
 )
 
 // Options holds the current options of the kernel.
 // They can be set using commands.
 type Options struct {
-	Server    string
-	TimeStart time.Time
-	TimeEnd   time.Time
-	NowFunc   func() time.Time
+	Server     string
+	TimeStart  time.Time
+	TimeEnd    time.Time
+	Location   *time.Location
+	Step       time.Duration
+	Resolution int
+	Sort       string
+	Unit       string
+	CacheTTL   time.Duration
+	// CompletionLimit is the maximum number of completion matches.
+	CompletionLimit int
+	// Lint is the level of query linting: off, warn or error.
+	Lint string
+	// LintSeries is the number of series above which unaggregated selectors cause a lint warning, zero disables the check.
+	LintSeries int
+	// Matchers are added to all selectors of executed queries and completion lookups.
+	Matchers []*promql.LabelMatcher
+	// Auth contains the credentials for the server, nil if no authentication is used.
+	Auth    *Credentials
+	NowFunc func() time.Time
+}
+
+// TimeZone returns the location used for displaying times. Defaults to UTC.
+func (o Options) TimeZone() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// optionNames contains the names of the options, which can be set using commands.
+var optionNames = []string{
+	"server",
+	"start",
+	"end",
+	"tz",
+	"step",
+	"resolution",
+	"unit",
+	"sort",
+	"cachettl",
+	"completionlimit",
+	"matchers",
+	"lint",
+	"lintseries",
+	"auth",
 }
 
 func (o Options) Pretty() string {
-	start := o.TimeStart.UTC().Format(time.RFC3339)
-	end := o.TimeEnd.UTC().Format(time.RFC3339)
+	loc := o.TimeZone()
+	start := o.TimeStart.In(loc).Format(time.RFC3339)
+	end := o.TimeEnd.In(loc).Format(time.RFC3339)
 	duration := o.TimeEnd.Sub(o.TimeStart)
-	return fmt.Sprintf("Server: %s\n  Time: %s - %s (%s)", o.Server, start, end, duration)
+	step := fmt.Sprintf("auto (%d points)", o.Resolution)
+	if o.Step > 0 {
+		step = model.Duration(o.Step).String()
+	}
+	pretty := fmt.Sprintf("Server: %s\n  Time: %s - %s (%s)\n  Zone: %s\n  Step: %s", o.Server, start, end, duration, loc, step)
+	if len(o.Matchers) > 0 {
+		pretty += "\n  Matchers: " + promql.MatchersString(o.Matchers)
+	}
+	if o.Auth != nil {
+		pretty += "\n  Auth: " + o.Auth.String()
+	}
+	return pretty
 }
 
-func (k *Kernel) handleOptions(input string) error {
+// handleOptions sets the options contained in the input. readInput is used to ask the user for credentials.
+func (k *Kernel) handleOptions(input string, readInput scaffold.InputFunc) error {
+	server, auth := k.Options.Server, k.Options.Auth
+	defer func() {
+		if k.Options.Server != server || k.Options.Auth != auth {
+			k.metadata.invalidate()
+			k.prefetchMetadata()
+		}
+	}()
+
 	commands := strings.Split(input, "\n")
 	for _, c := range commands {
-		tokens := strings.SplitN(strings.TrimPrefix(c, "@"), "=", 2)
-		if len(tokens) != 2 {
+		key, value, ok := splitOption(c)
+		if !ok {
 			return fmt.Errorf("not an assignment: %s", c)
 		}
 
-		key := strings.TrimSpace(strings.ToLower(tokens[0]))
-		value := strings.TrimSpace(tokens[1])
 		switch key {
 		case "server":
+			// Credentials are only sent to the server they were entered for.
+			if value != k.Options.Server {
+				k.Options.Auth = nil
+			}
 			k.Options.Server = value
 		case "timestart", "start":
 			if err := setTime(&k.Options.TimeStart, value, k.Options); err != nil {
@@ -44,6 +114,60 @@ func (k *Kernel) handleOptions(input string) error {
 			if err := setTime(&k.Options.TimeEnd, value, k.Options); err != nil {
 				return err
 			}
+		case "tz", "timezone":
+			loc, err := time.LoadLocation(value)
+			if err != nil {
+				return fmt.Errorf("not a valid time zone: %s", value)
+			}
+			k.Options.Location = loc
+		case "step":
+			if err := setStep(&k.Options.Step, value); err != nil {
+				return err
+			}
+		case "resolution":
+			resolution, err := strconv.Atoi(value)
+			if err != nil || resolution <= 0 {
+				return fmt.Errorf("not a valid resolution: %s", value)
+			}
+			k.Options.Resolution = resolution
+		case "unit":
+			if err := setUnit(&k.Options.Unit, value); err != nil {
+				return err
+			}
+		case "sort":
+			if err := setSort(&k.Options.Sort, value); err != nil {
+				return err
+			}
+		case "cachettl":
+			ttl, err := model.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("not a valid duration: %s", value)
+			}
+			k.Options.CacheTTL = time.Duration(ttl)
+		case "completionlimit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return fmt.Errorf("not a valid limit: %s", value)
+			}
+			k.Options.CompletionLimit = limit
+		case "lint":
+			if err := setLint(&k.Options.Lint, value); err != nil {
+				return err
+			}
+		case "lintseries":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return fmt.Errorf("not a valid number of series: %s", value)
+			}
+			k.Options.LintSeries = limit
+		case "matchers":
+			if err := setMatchers(&k.Options.Matchers, value); err != nil {
+				return err
+			}
+		case "auth":
+			if err := setAuth(&k.Options.Auth, value, k.Options.Server, readInput); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("not a valid option: %s", key)
 		}
@@ -51,6 +175,65 @@ func (k *Kernel) handleOptions(input string) error {
 	return nil
 }
 
+// splitOption splits an option like "@key=value" into the lower-case key and the value.
+// Values starting with a brace can also be separated by whitespace, for example "@matchers {cluster="eu-1"}".
+func splitOption(line string) (key, value string, ok bool) {
+	line = strings.TrimPrefix(line, "@")
+	i := strings.IndexAny(line, "={")
+	if i < 0 {
+		return "", "", false
+	}
+
+	key = strings.TrimSpace(strings.ToLower(line[:i]))
+	value = line[i:]
+	if line[i] == '=' {
+		value = line[i+1:]
+	}
+	return key, strings.TrimSpace(value), true
+}
+
+func setMatchers(v *[]*promql.LabelMatcher, value string) error {
+	if value == "" || value == "{}" || strings.ToLower(value) == "none" {
+		*v = nil
+		return nil
+	}
+
+	matchers, err := promql.ParseMatchers(value)
+	if err != nil {
+		return fmt.Errorf("not valid matchers: %s", err)
+	}
+
+	*v = matchers
+	return nil
+}
+
+func setStep(v *time.Duration, value string) error {
+	if strings.ToLower(value) == "auto" {
+		*v = 0
+		return nil
+	}
+
+	step, err := model.ParseDuration(value)
+	if err != nil || step <= 0 {
+		return fmt.Errorf("not a valid step: %s", value)
+	}
+
+	*v = time.Duration(step)
+	return nil
+}
+
+func setSort(v *string, value string) error {
+	switch key := strings.TrimPrefix(value, "-"); {
+	case value == "" || strings.ToLower(value) == "none":
+		*v = ""
+	case key == sortByValue || model.LabelName(key).IsValid():
+		*v = value
+	default:
+		return fmt.Errorf("not a valid sort order: %s", value)
+	}
+	return nil
+}
+
 var relativeRegex = regexp.MustCompile(`^(now|start|end)\W*(([+-])\W*(.+))?$`)
 
 func setTime(v *time.Time, value string, options Options) error {
@@ -3,8 +3,11 @@ package kernel
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/xperimental/ipromnb/promql"
 )
 
 func timeFunc(ts time.Time) func() time.Time {
@@ -118,3 +121,199 @@ func TestSetTime(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeZoneOption(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		zone  string
+		err   error
+	}{
+		{
+			desc:  "utc",
+			input: "@tz=UTC",
+			zone:  "UTC",
+		},
+		{
+			desc:  "location",
+			input: "@tz=Europe/Berlin",
+			zone:  "Europe/Berlin",
+		},
+		{
+			desc:  "long name",
+			input: "@timezone=America/New_York",
+			zone:  "America/New_York",
+		},
+		{
+			desc:  "invalid",
+			input: "@tz=Mars/Olympus_Mons",
+			err:   errors.New("not a valid time zone: Mars/Olympus_Mons"),
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			err := k.handleOptions(test.input, nil)
+
+			if !reflect.DeepEqual(err, test.err) {
+				t.Errorf("got error %q, want %q", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if zone := k.Options.TimeZone().String(); zone != test.zone {
+				t.Errorf("got zone %q, want %q", zone, test.zone)
+			}
+		})
+	}
+}
+
+func TestStepOptions(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		input      string
+		step       time.Duration
+		resolution int
+		err        error
+	}{
+		{
+			desc:       "fixed step",
+			input:      "@step=30s",
+			step:       30 * time.Second,
+			resolution: defaultResolution,
+		},
+		{
+			desc:       "days",
+			input:      "@step=1d",
+			step:       24 * time.Hour,
+			resolution: defaultResolution,
+		},
+		{
+			desc:       "auto step",
+			input:      "@step=30s\n@step=auto",
+			step:       0,
+			resolution: defaultResolution,
+		},
+		{
+			desc:       "resolution",
+			input:      "@resolution=1000",
+			resolution: 1000,
+		},
+		{
+			desc:  "invalid step",
+			input: "@step=fast",
+			err:   errors.New("not a valid step: fast"),
+		},
+		{
+			desc:  "invalid resolution",
+			input: "@resolution=0",
+			err:   errors.New("not a valid resolution: 0"),
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			err := k.handleOptions(test.input, nil)
+
+			if !reflect.DeepEqual(err, test.err) {
+				t.Errorf("got error %q, want %q", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if k.Options.Step != test.step {
+				t.Errorf("got step %s, want %s", k.Options.Step, test.step)
+			}
+
+			if k.Options.Resolution != test.resolution {
+				t.Errorf("got resolution %d, want %d", k.Options.Resolution, test.resolution)
+			}
+		})
+	}
+}
+
+func TestMatchersOption(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		input    string
+		matchers string
+		err      bool
+	}{
+		{
+			desc:     "whitespace",
+			input:    `@matchers {cluster="eu-1", env="prod"}`,
+			matchers: `{cluster="eu-1", env="prod"}`,
+		},
+		{
+			desc:     "assignment",
+			input:    `@matchers={cluster=~"eu-.*"}`,
+			matchers: `{cluster=~"eu-.*"}`,
+		},
+		{
+			desc:     "reset",
+			input:    "@matchers {cluster=\"eu-1\"}\n@matchers=none",
+			matchers: "{}",
+		},
+		{
+			desc:  "metric name",
+			input: `@matchers=up{job="a"}`,
+			err:   true,
+		},
+		{
+			desc:  "syntax error",
+			input: `@matchers {cluster=}`,
+			err:   true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			err := k.handleOptions(test.input, nil)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, wanted error %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if matchers := promql.MatchersString(k.Options.Matchers); matchers != test.matchers {
+				t.Errorf("got matchers %s, wanted %s", matchers, test.matchers)
+			}
+
+			if pretty := k.Options.Pretty(); len(k.Options.Matchers) > 0 && !strings.Contains(pretty, "\n  Matchers: "+test.matchers) {
+				t.Errorf("got options %q, wanted matchers %s", pretty, test.matchers)
+			}
+		})
+	}
+}
+
+func TestScopeSelectors(t *testing.T) {
+	k := New("")
+	if err := k.handleOptions(`@matchers {cluster="eu-1"}`, nil); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	for _, test := range []struct {
+		selectors []string
+		out       []string
+	}{
+		{nil, []string{`{cluster="eu-1"}`}},
+		{[]string{"up", "node_load1"}, []string{`up{cluster="eu-1"}`, `node_load1{cluster="eu-1"}`}},
+	} {
+		out := k.scopeSelectors(test.selectors)
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("got %q, wanted %q", out, test.out)
+		}
+	}
+}
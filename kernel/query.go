@@ -1,214 +1,277 @@
 package kernel
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"regexp"
 	"strconv"
 	"time"
 
-	"github.com/gonum/plot/palette/brewer"
-	"github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/promql"
 	"github.com/xperimental/ipromnb/scaffold"
-	"gonum.org/v1/plot"
-	"gonum.org/v1/plot/plotter"
-	"gonum.org/v1/plot/vg"
-	"gonum.org/v1/plot/vg/draw"
 )
 
 var (
 	errNoMetrics = errors.New("no matching metrics")
 )
 
-func (k *Kernel) handleQuery(ctx context.Context, count int, code string,
-	stream func(name, text string), displayData scaffold.DisplayFunc) (string, error) {
+// handleQuery executes a query or command and returns the executed query and its result.
+// cell is the ID of the executed cell, if the frontend sends one. It identifies commands running in the background.
+func (k *Kernel) handleQuery(ctx context.Context, code, cell string, stream func(name, text string),
+	displayData scaffold.DisplayFunc, setNextInput func(text string)) (_ string, _ *scaffold.DisplayData, err error) {
 
-	if match := graphRegex.FindStringSubmatch(code); match != nil {
-		zero := match[1] == "0"
-		query := match[2]
+	cmd, err := parseCommand(code)
+	if err != nil {
+		return "", nil, err
+	}
 
-		result, err := k.handleRangeQuery(ctx, query, k.Options.TimeStart, k.Options.TimeEnd, zero)
-		if err != nil {
-			return "", err
+	if cmd == nil {
+		cmd = &command{
+			Name:  "table",
+			Query: code,
 		}
+	}
 
-		displayData(&scaffold.DisplayData{
-			Data: map[string]interface{}{
-				"image/png": result,
-			},
-		}, false)
-
-		return query, nil
+	if cmd.Name == "history" {
+		result, err := k.handleHistory(cmd)
+		return "", result, err
 	}
 
-	result, err := k.handleInstantQuery(ctx, code, k.Options.TimeEnd)
+	// The local parser does not know every function supported by newer servers. Unless the syntax tree is needed,
+	// a query calling an unknown function is sent to the server unchanged. If the server rejects it too,
+	// the local syntax error is shown.
+	expr, err := promql.ParseExpr(cmd.Query)
 	if err != nil {
-		return "", err
-	}
+		parseErr := err.(*promql.ParseError)
+		if !parseErr.Unknown || k.Options.Server == "" || cmd.Name == "format" || cmd.Name == "explain" || len(k.Options.Matchers) > 0 {
+			return "", nil, newSyntaxError(code, cmd.Query, parseErr)
+		}
 
-	displayData(&scaffold.DisplayData{
-		Data: map[string]interface{}{
-			"text/html": result,
-		},
-	}, false)
+		defer func() {
+			if err != nil && err != errNoMetrics && ctx.Err() == nil {
+				err = newSyntaxError(code, cmd.Query, parseErr)
+			}
+		}()
+	}
 
-	return code, nil
-}
+	if expr != nil && cmd.Name != "format" {
+		if len(k.Options.Matchers) > 0 {
+			promql.InjectMatchers(expr, k.Options.Matchers)
+			cmd.Query = promql.String(expr)
+		}
 
-func (k *Kernel) getAPI() (promv1.API, error) {
-	if k.Options.Server == "" {
-		return nil, fmt.Errorf("no server set. set one using @server=<url>")
+		if err := k.lintQuery(ctx, expr, stream); err != nil {
+			return "", nil, err
+		}
 	}
 
-	client, err := api.NewClient(api.Config{
-		Address: k.Options.Server,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create client: %s", err)
+	unit := k.Options.Unit
+	if value, ok := cmd.Args["unit"]; ok {
+		if err := setUnit(&unit, value); err != nil {
+			return "", nil, err
+		}
 	}
 
-	return promv1.NewAPI(client), nil
-}
+	switch cmd.Name {
+	case "format":
+		if promql.HasComment(code) {
+			return "", nil, errors.New("can not format queries containing comments, they would be removed")
+		}
 
-func (k *Kernel) handleInstantQuery(ctx context.Context, query string, instant time.Time) (string, error) {
-	api, err := k.getAPI()
-	if err != nil {
-		return "", err
-	}
+		formatted := promql.Format(expr)
+		setNextInput(formatted)
 
-	value, err := api.Query(ctx, query, instant)
-	if err != nil {
-		return "", fmt.Errorf("query failed: %s", err)
-	}
+		return cmd.Query, &scaffold.DisplayData{
+			Data: map[string]interface{}{
+				"text/plain": formatted,
+			},
+		}, nil
+	case "explain":
+		tree, err := k.handleExplain(ctx, expr, k.Options.TimeEnd)
+		if err != nil {
+			return "", nil, err
+		}
 
-	result, ok := value.(model.Vector)
-	if !ok {
-		return "", fmt.Errorf("can not convert to vector: %t", value)
-	}
+		return cmd.Query, &scaffold.DisplayData{
+			Data: map[string]interface{}{
+				"text/html":  tree.HTML(k.Options.TimeEnd, k.Options.TimeZone()),
+				"text/plain": tree.Text(),
+			},
+		}, nil
+	case "watch":
+		result, err := k.handleWatch(ctx, cmd, cell, code, unit, displayData)
+		if err != nil {
+			return "", nil, err
+		}
 
-	if len(result) == 0 {
-		return "", errNoMetrics
+		return cmd.Query, result, nil
+	case "graph", "graph0":
+		zero := cmd.Name == "graph0"
+		follow := false
+		if value, ok := cmd.Args["follow"]; ok {
+			if follow, err = strconv.ParseBool(value); err != nil {
+				return "", nil, fmt.Errorf("not a valid value for follow: %s", value)
+			}
+		}
+
+		result, err := k.handleRangeQuery(ctx, cmd.Query, k.Options.TimeStart, k.Options.TimeEnd, zero, unit)
+		if err != nil {
+			return "", nil, err
+		}
+
+		data := &scaffold.DisplayData{
+			Data: map[string]interface{}{
+				"image/png": result,
+			},
+		}
+		if follow {
+			data.Transient = map[string]interface{}{
+				"display_id": k.addFollowGraph(cmd.Query, zero, unit),
+			}
+		}
+		return cmd.Query, data, nil
 	}
 
-	output := &bytes.Buffer{}
-	fmt.Fprintln(output, "<table><thead><tr><th>Metric</th><th>Value</th></thead><tbody>")
-	for _, m := range result {
-		fmt.Fprintln(output, fmt.Sprintf("<tr><td>%s</td><td>%f</td>", m.Metric, m.Value))
+	table, err := k.handleInstantQuery(ctx, cmd.Query, k.Options.TimeEnd, unit)
+	if err != nil {
+		return "", nil, err
 	}
-	fmt.Fprintf(output, "</tbody></table>")
 
-	return output.String(), nil
+	return cmd.Query, &scaffold.DisplayData{
+		Data: map[string]interface{}{
+			"text/html":        table.HTML(k.Options.TimeZone()),
+			"text/plain":       table.Text(k.Options.TimeZone()),
+			"application/json": table.JSON(),
+		},
+	}, nil
 }
 
-const (
-	imageWidth  = 640
-	imageHeight = 480
-)
-
-// Only show important part of metric name
-var labelText = regexp.MustCompile("\\{(.*)\\}")
-
-func (k *Kernel) handleRangeQuery(ctx context.Context, query string, start, end time.Time, zero bool) ([]byte, error) {
-	duration := k.Options.TimeEnd.Sub(k.Options.TimeStart)
-	rng := promv1.Range{
-		Start: k.Options.TimeStart,
-		End:   k.Options.TimeEnd,
-		Step:  duration / 320,
+func (k *Kernel) getAPI() (promv1.API, error) {
+	client, err := k.getClient()
+	if err != nil {
+		return nil, err
 	}
 
+	return promv1.NewAPI(client), nil
+}
+
+func (k *Kernel) handleInstantQuery(ctx context.Context, query string, instant time.Time, unit string) (*instantTable, error) {
 	api, err := k.getAPI()
 	if err != nil {
 		return nil, err
 	}
 
-	value, err := api.QueryRange(ctx, query, rng)
+	value, err := api.Query(ctx, query, instant)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %s", err)
 	}
 
-	metrics, ok := value.(model.Matrix)
-	if !ok {
-		return nil, fmt.Errorf("failed to convert to matrix: %t", value)
+	var result model.Vector
+	switch v := value.(type) {
+	case model.Vector:
+		result = v
+	case *model.Scalar:
+		result = model.Vector{
+			{
+				Metric:    model.Metric{},
+				Value:     v.Value,
+				Timestamp: v.Timestamp,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("can not convert to vector: %t", value)
 	}
 
-	if len(metrics) == 0 {
+	if len(result) == 0 {
 		return nil, errNoMetrics
 	}
 
-	return plotResult(metrics, zero)
+	table := newInstantTable(result, k.Options.Sort, instant)
+	table.Unit = resultUnit(unit, query, vectorNames(result))
+	return table, nil
 }
 
-func plotResult(metrics model.Matrix, zero bool) ([]byte, error) {
-	p, err := plot.New()
+func (k *Kernel) handleRangeQuery(ctx context.Context, query string, start, end time.Time, zero bool, unit string) ([]byte, error) {
+	metrics, step, err := k.queryRange(ctx, query, start, end)
 	if err != nil {
-		return nil, fmt.Errorf("error creating plotter: %s", err)
+		return nil, err
 	}
 
-	textFont, err := vg.MakeFont("Helvetica", 3*vg.Millimeter)
+	return plotResult(metrics, graphOptions{
+		Zero:     zero,
+		Step:     step,
+		Duration: end.Sub(start),
+		Location: k.Options.TimeZone(),
+		Unit:     resultUnit(unit, query, matrixNames(metrics)),
+	})
+}
+
+// queryRange runs a range query between start and end. The step is either set by the options or
+// selected automatically. Queries with too many points are split up into multiple requests.
+func (k *Kernel) queryRange(ctx context.Context, query string, start, end time.Time) (model.Matrix, time.Duration, error) {
+	api, err := k.getAPI()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load font: %v", err)
+		return nil, 0, err
 	}
 
-	p.X.Tick.Marker = plot.TimeTicks{Format: "15:04"}
-	p.X.Tick.Label.Font = textFont
-	p.Y.Tick.Label.Font = textFont
-	p.Legend.Font = textFont
-	p.Legend.YOffs = 10 * vg.Millimeter
-
-	if zero {
-		p.Y.Min = 0
+	step := k.Options.Step
+	if step == 0 {
+		step = autoStep(end.Sub(start), k.Options.Resolution, k.scrapeInterval(ctx, api))
 	}
 
-	// Color palette for drawing lines
-	paletteSize := 8
-	palette, err := brewer.GetPalette(brewer.TypeAny, "Dark2", paletteSize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get color palette: %v", err)
-	}
-	colors := palette.Colors()
-
-	for s, sample := range metrics {
-		data := make(plotter.XYs, len(sample.Values))
-		for i, v := range sample.Values {
-			data[i].X = float64(v.Timestamp.Unix())
-			f, err := strconv.ParseFloat(v.Value.String(), 64)
-			if err != nil {
-				return nil, fmt.Errorf("sample value not float: %s", v.Value.String())
-			}
-			data[i].Y = f
+	start, end = alignRange(start, end, step)
+	chunks := splitRange(promv1.Range{
+		Start: start,
+		End:   end,
+		Step:  step,
+	}, maxRangePoints)
+
+	parts := make([]model.Matrix, 0, len(chunks))
+	for _, rng := range chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
 		}
 
-		l, err := plotter.NewLine(data)
+		value, err := api.QueryRange(ctx, query, rng)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create line: %v", err)
+			return nil, 0, fmt.Errorf("query failed: %s", err)
 		}
-		l.LineStyle.Width = vg.Points(1)
-		l.LineStyle.Color = colors[s%paletteSize]
 
-		p.Add(l)
-		if len(metrics) > 1 {
-			m := labelText.FindStringSubmatch(sample.Metric.String())
-			if m != nil {
-				p.Legend.Add(m[1], l)
-			}
+		metrics, ok := value.(model.Matrix)
+		if !ok {
+			return nil, 0, fmt.Errorf("failed to convert to matrix: %t", value)
 		}
+		parts = append(parts, metrics)
 	}
 
-	c, err := draw.NewFormattedCanvas(imageWidth, imageHeight, "png")
-	if err != nil {
-		return nil, fmt.Errorf("error creating canvas: %s", err)
+	metrics := mergeMatrices(parts)
+	if len(metrics) == 0 {
+		return nil, 0, errNoMetrics
 	}
 
-	p.Draw(draw.New(c))
+	return metrics, step, nil
+}
 
-	buf := &bytes.Buffer{}
-	if _, err := c.WriteTo(buf); err != nil {
-		return nil, fmt.Errorf("error writing image: %s", err)
+// vectorNames returns the metric names contained in a vector.
+func vectorNames(vector model.Vector) []string {
+	names := []string{}
+	for _, s := range vector {
+		if name, ok := s.Metric[model.MetricNameLabel]; ok {
+			names = append(names, string(name))
+		}
 	}
+	return names
+}
 
-	return buf.Bytes(), nil
+// matrixNames returns the metric names contained in a matrix.
+func matrixNames(matrix model.Matrix) []string {
+	names := []string{}
+	for _, s := range matrix {
+		if name, ok := s.Metric[model.MetricNameLabel]; ok {
+			names = append(names, string(name))
+		}
+	}
+	return names
 }
@@ -0,0 +1,215 @@
+package kernel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+const (
+	// rangeWidgetTarget is the comm target used by the time range widget.
+	rangeWidgetTarget = "ipromnb.range"
+	// rangeWidgetTimeFormat is the format of datetime-local inputs.
+	rangeWidgetTimeFormat = "2006-01-02T15:04"
+	// maxFollowGraphs is the number of graphs which are kept for re-rendering, older graphs stop following the range.
+	maxFollowGraphs = 20
+)
+
+// rangeWidgetQuickRanges are the ranges which can be selected with one click.
+var rangeWidgetQuickRanges = []string{"1h", "6h", "24h", "7d"}
+
+// followGraph is a graph which is rendered again when the time range is changed using the widget.
+type followGraph struct {
+	DisplayID string
+	Query     string
+	Zero      bool
+	Unit      string
+}
+
+// RegisterComms implements scaffold.CommHandlers.
+func (k *Kernel) RegisterComms(comms *scaffold.CommManager) {
+	k.comms = comms
+	comms.RegisterTarget(rangeWidgetTarget, &rangeWidget{k})
+}
+
+// addFollowGraph remembers a graph for re-rendering and returns its display ID.
+func (k *Kernel) addFollowGraph(query string, zero bool, unit string) string {
+	graph := &followGraph{
+		DisplayID: newDisplayID(),
+		Query:     query,
+		Zero:      zero,
+		Unit:      unit,
+	}
+	k.followGraphs = append(k.followGraphs, graph)
+	if len(k.followGraphs) > maxFollowGraphs {
+		k.followGraphs = k.followGraphs[len(k.followGraphs)-maxFollowGraphs:]
+	}
+	return graph.DisplayID
+}
+
+// newDisplayID returns a random ID for outputs which are updated later.
+func newDisplayID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Sprintf("rand.Read failed: %v", err))
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// rangeWidget handles the comms of time range widgets.
+type rangeWidget struct {
+	k *Kernel
+}
+
+func (w *rangeWidget) HandleOpen(ctx context.Context, comm *scaffold.Comm, data map[string]interface{}) error {
+	comm.Send(w.state())
+	return nil
+}
+
+func (w *rangeWidget) HandleMessage(ctx context.Context, comm *scaffold.Comm, data map[string]interface{}) {
+	if err := w.setRange(data); err != nil {
+		comm.Send(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.k.updateFollowGraphs(ctx, comm.Display)
+
+	// All widgets show the same range.
+	state := w.state()
+	for _, c := range w.k.comms.Comms(rangeWidgetTarget) {
+		c.Send(state)
+	}
+}
+
+func (w *rangeWidget) HandleClose(ctx context.Context, comm *scaffold.Comm, data map[string]interface{}) {
+}
+
+// state returns the current time range in the format of the widget.
+func (w *rangeWidget) state() map[string]interface{} {
+	loc := w.k.Options.TimeZone()
+	return map[string]interface{}{
+		"start": w.k.Options.TimeStart.In(loc).Format(rangeWidgetTimeFormat),
+		"end":   w.k.Options.TimeEnd.In(loc).Format(rangeWidgetTimeFormat),
+	}
+}
+
+// setRange sets the time range sent by the widget, which is either a quick range like "6h"
+// ending now or a start and end time.
+func (w *rangeWidget) setRange(data map[string]interface{}) error {
+	options := &w.k.Options
+
+	if value, ok := data["range"].(string); ok {
+		duration, err := model.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("not a valid range: %s", value)
+		}
+
+		options.TimeEnd = options.NowFunc()
+		options.TimeStart = options.TimeEnd.Add(-time.Duration(duration))
+		return nil
+	}
+
+	startValue, _ := data["start"].(string)
+	endValue, _ := data["end"].(string)
+	start, err := time.ParseInLocation(rangeWidgetTimeFormat, startValue, options.TimeZone())
+	if err != nil {
+		return fmt.Errorf("not a valid start time: %s", startValue)
+	}
+	end, err := time.ParseInLocation(rangeWidgetTimeFormat, endValue, options.TimeZone())
+	if err != nil {
+		return fmt.Errorf("not a valid end time: %s", endValue)
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("start time needs to be before end time")
+	}
+
+	options.TimeStart, options.TimeEnd = start, end
+	return nil
+}
+
+// updateFollowGraphs renders all graphs in follow mode using the current time range.
+// It stops when the context is cancelled, the remaining graphs keep showing the old range.
+func (k *Kernel) updateFollowGraphs(ctx context.Context, displayData scaffold.DisplayFunc) {
+	for _, graph := range k.followGraphs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		data := map[string]interface{}{}
+		result, err := k.handleRangeQuery(ctx, graph.Query, k.Options.TimeStart, k.Options.TimeEnd, graph.Zero, graph.Unit)
+		if err != nil {
+			data["text/plain"] = fmt.Sprintf("Error executing query: %s", err)
+		} else {
+			data["image/png"] = result
+		}
+
+		displayData(&scaffold.DisplayData{
+			Data: data,
+			Transient: map[string]interface{}{
+				"display_id": graph.DisplayID,
+			},
+		}, true)
+	}
+}
+
+// handleRangeWidget returns the time range widget. The widget opens a comm to the kernel,
+// which needs the classic notebook.
+func (k *Kernel) handleRangeWidget() *scaffold.DisplayData {
+	id := "ipromnb-range-" + newDisplayID()
+
+	buttons := ""
+	for _, r := range rangeWidgetQuickRanges {
+		buttons += fmt.Sprintf(`<button class="btn btn-default btn-xs" data-range="%s">%s</button> `, r, r)
+	}
+
+	return &scaffold.DisplayData{
+		Data: map[string]interface{}{
+			"text/html": fmt.Sprintf(rangeWidgetHTML, id, buttons, id, rangeWidgetTarget),
+			"text/plain": fmt.Sprintf("Time range widget (%s - %s), it needs a frontend which runs JavaScript in outputs.",
+				k.Options.TimeStart.In(k.Options.TimeZone()).Format(time.RFC3339), k.Options.TimeEnd.In(k.Options.TimeZone()).Format(time.RFC3339)),
+		},
+	}
+}
+
+const rangeWidgetHTML = `<div id="%s" class="ipromnb-range">
+<input type="datetime-local" class="start"> &ndash; <input type="datetime-local" class="end">
+<button class="btn btn-default btn-xs apply">Apply</button>
+%s
+<span class="status"></span>
+</div>
+<script>
+(function() {
+  var root = document.getElementById("%s");
+  var start = root.querySelector(".start");
+  var end = root.querySelector(".end");
+  var status = root.querySelector(".status");
+  var comm = Jupyter.notebook.kernel.comm_manager.new_comm("%s", {});
+  comm.on_msg(function(msg) {
+    var data = msg.content.data;
+    if (data.error) {
+      status.textContent = data.error;
+      return;
+    }
+    start.value = data.start;
+    end.value = data.end;
+    status.textContent = "";
+  });
+  root.querySelector(".apply").onclick = function() {
+    status.textContent = "Updating...";
+    comm.send({start: start.value, end: end.value});
+  };
+  root.querySelectorAll("[data-range]").forEach(function(button) {
+    button.onclick = function() {
+      status.textContent = "Updating...";
+      comm.send({range: button.getAttribute("data-range")});
+    };
+  });
+})();
+</script>`
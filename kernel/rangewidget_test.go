@@ -0,0 +1,120 @@
+package kernel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+func TestRangeWidgetSetRange(t *testing.T) {
+	now := time.Date(2018, 8, 8, 20, 30, 0, 0, time.UTC)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("can not load location: %s", err)
+	}
+
+	for _, test := range []struct {
+		desc     string
+		location *time.Location
+		data     map[string]interface{}
+		start    time.Time
+		end      time.Time
+		err      bool
+	}{
+		{
+			desc:  "quick range",
+			data:  map[string]interface{}{"range": "6h"},
+			start: now.Add(-6 * time.Hour),
+			end:   now,
+		},
+		{
+			desc:  "quick range in days",
+			data:  map[string]interface{}{"range": "7d"},
+			start: now.Add(-7 * 24 * time.Hour),
+			end:   now,
+		},
+		{
+			desc:  "start and end",
+			data:  map[string]interface{}{"start": "2018-08-01T10:00", "end": "2018-08-01T12:30"},
+			start: time.Date(2018, 8, 1, 10, 0, 0, 0, time.UTC),
+			end:   time.Date(2018, 8, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			desc:     "time zone",
+			location: berlin,
+			data:     map[string]interface{}{"start": "2018-08-01T10:00", "end": "2018-08-01T12:30"},
+			start:    time.Date(2018, 8, 1, 8, 0, 0, 0, time.UTC),
+			end:      time.Date(2018, 8, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			desc: "end before start",
+			data: map[string]interface{}{"start": "2018-08-01T12:00", "end": "2018-08-01T10:00"},
+			err:  true,
+		},
+		{
+			desc: "invalid range",
+			data: map[string]interface{}{"range": "soon"},
+			err:  true,
+		},
+		{
+			desc: "missing end",
+			data: map[string]interface{}{"start": "2018-08-01T12:00"},
+			err:  true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			k.Options.NowFunc = timeFunc(now)
+			k.Options.Location = test.location
+			w := &rangeWidget{k}
+
+			err := w.setRange(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, wanted error %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !k.Options.TimeStart.Equal(test.start) {
+				t.Errorf("got start %s, wanted %s", k.Options.TimeStart, test.start)
+			}
+			if !k.Options.TimeEnd.Equal(test.end) {
+				t.Errorf("got end %s, wanted %s", k.Options.TimeEnd, test.end)
+			}
+		})
+	}
+}
+
+func TestAddFollowGraph(t *testing.T) {
+	k := New("")
+	var ids []string
+	for i := 0; i < maxFollowGraphs+2; i++ {
+		ids = append(ids, k.addFollowGraph("up", false, unitAuto))
+	}
+
+	if len(k.followGraphs) != maxFollowGraphs {
+		t.Fatalf("got %d graphs, wanted %d", len(k.followGraphs), maxFollowGraphs)
+	}
+	if k.followGraphs[0].DisplayID != ids[2] {
+		t.Errorf("got first graph %s, wanted %s", k.followGraphs[0].DisplayID, ids[2])
+	}
+}
+
+func TestUpdateFollowGraphsCancelled(t *testing.T) {
+	k := New("http://prometheus:9090")
+	k.addFollowGraph("up", false, unitAuto)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	k.updateFollowGraphs(ctx, func(data *scaffold.DisplayData, update bool) {
+		t.Errorf("graph updated after the context was cancelled: %v", data.Data)
+	})
+}
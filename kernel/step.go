@@ -0,0 +1,180 @@
+package kernel
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// defaultResolution is the number of points per graph, when the step is selected automatically.
+	defaultResolution = 320
+	// maxRangePoints is the maximum number of points per series Prometheus returns for a range query.
+	maxRangePoints = 11000
+)
+
+// niceSteps are the steps used when selecting the step automatically.
+var niceSteps = []time.Duration{
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	2 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// autoStep returns a step, so that a range query over duration returns at most resolution points.
+// The step is rounded up to a "nice" value and is never lower than minStep.
+func autoStep(duration time.Duration, resolution int, minStep time.Duration) time.Duration {
+	if resolution <= 0 {
+		resolution = defaultResolution
+	}
+
+	raw := duration / time.Duration(resolution)
+	step := niceSteps[len(niceSteps)-1]
+	if raw > step {
+		// Use multiples of the largest step
+		step = (raw + step - 1) / step * step
+	} else {
+		for _, s := range niceSteps {
+			if s >= raw {
+				step = s
+				break
+			}
+		}
+	}
+
+	if step < minStep {
+		step = minStep
+	}
+
+	return step
+}
+
+// alignRange aligns start and end of a range to multiples of step, so that re-running a query
+// returns samples at the same timestamps. The start is moved backwards and the end is moved forwards.
+func alignRange(start, end time.Time, step time.Duration) (time.Time, time.Time) {
+	stepMs := int64(step / time.Millisecond)
+	if stepMs <= 0 {
+		return start, end
+	}
+
+	startMs := int64(model.TimeFromUnixNano(start.UnixNano()))
+	startMs -= startMs % stepMs
+
+	endMs := int64(model.TimeFromUnixNano(end.UnixNano()))
+	if rest := endMs % stepMs; rest != 0 {
+		endMs += stepMs - rest
+	}
+
+	return model.Time(startMs).Time(), model.Time(endMs).Time()
+}
+
+// splitRange splits a range into consecutive chunks which do not contain more than maxPoints points each.
+func splitRange(rng promv1.Range, maxPoints int) []promv1.Range {
+	if rng.Step <= 0 || maxPoints <= 1 {
+		return []promv1.Range{rng}
+	}
+
+	chunkLength := time.Duration(maxPoints-1) * rng.Step
+	chunks := []promv1.Range{}
+	for start := rng.Start; !start.After(rng.End); {
+		end := start.Add(chunkLength)
+		if end.After(rng.End) {
+			end = rng.End
+		}
+
+		chunks = append(chunks, promv1.Range{
+			Start: start,
+			End:   end,
+			Step:  rng.Step,
+		})
+		start = end.Add(rng.Step)
+	}
+	return chunks
+}
+
+// mergeMatrices merges the results of multiple range queries into one result.
+// The parts need to be ordered by time and not overlap.
+func mergeMatrices(parts []model.Matrix) model.Matrix {
+	result := model.Matrix{}
+	streams := map[model.Fingerprint]*model.SampleStream{}
+	for _, part := range parts {
+		for _, stream := range part {
+			fp := stream.Metric.Fingerprint()
+			existing, ok := streams[fp]
+			if !ok {
+				existing = &model.SampleStream{
+					Metric: stream.Metric,
+				}
+				streams[fp] = existing
+				result = append(result, existing)
+			}
+
+			existing.Values = append(existing.Values, stream.Values...)
+		}
+	}
+	return result
+}
+
+// The first scrape_interval in the configuration is the global one.
+var scrapeIntervalRegex = regexp.MustCompile(`(?m)^\s*scrape_interval:\s*(\S+)\s*$`)
+
+// scrapeIntervalRetry is the time after which the scrape interval is requested again, when it could not be fetched.
+const scrapeIntervalRetry = 5 * time.Minute
+
+type scrapeIntervalEntry struct {
+	interval time.Duration
+	// expires is zero, if the interval was fetched successfully.
+	expires time.Time
+}
+
+// scrapeInterval returns the global scrape interval of the current server.
+// Returns zero if it could not be determined.
+func (k *Kernel) scrapeInterval(ctx context.Context, api promv1.API) time.Duration {
+	server := k.Options.Server
+
+	k.mutex.Lock()
+	entry, ok := k.scrapeIntervals[server]
+	k.mutex.Unlock()
+	if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry.interval
+	}
+
+	config, err := api.Config(ctx)
+	if err != nil && ctx.Err() != nil {
+		// Request was cancelled, try again next time.
+		return 0
+	}
+
+	entry = scrapeIntervalEntry{}
+	if err != nil {
+		entry.expires = time.Now().Add(scrapeIntervalRetry)
+	} else if match := scrapeIntervalRegex.FindStringSubmatch(config.YAML); match != nil {
+		if d, err := model.ParseDuration(match[1]); err == nil {
+			entry.interval = time.Duration(d)
+		}
+	}
+
+	k.mutex.Lock()
+	k.scrapeIntervals[server] = entry
+	k.mutex.Unlock()
+	return entry.interval
+}
@@ -0,0 +1,251 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestAutoStep(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		duration   time.Duration
+		resolution int
+		minStep    time.Duration
+		step       time.Duration
+	}{
+		{
+			desc:       "day",
+			duration:   24 * time.Hour,
+			resolution: 320,
+			step:       5 * time.Minute,
+		},
+		{
+			desc:       "hour",
+			duration:   time.Hour,
+			resolution: 320,
+			step:       15 * time.Second,
+		},
+		{
+			desc:       "exact",
+			duration:   time.Hour,
+			resolution: 60,
+			step:       time.Minute,
+		},
+		{
+			desc:       "minimum step",
+			duration:   time.Hour,
+			resolution: 320,
+			minStep:    30 * time.Second,
+			step:       30 * time.Second,
+		},
+		{
+			desc:       "very short",
+			duration:   time.Minute,
+			resolution: 320,
+			step:       time.Second,
+		},
+		{
+			desc:       "very long",
+			duration:   10 * 365 * 24 * time.Hour,
+			resolution: 100,
+			step:       42 * 24 * time.Hour,
+		},
+		{
+			desc:       "default resolution",
+			duration:   24 * time.Hour,
+			resolution: 0,
+			step:       5 * time.Minute,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			step := autoStep(test.duration, test.resolution, test.minStep)
+			if step != test.step {
+				t.Errorf("got step %s, want %s", step, test.step)
+			}
+		})
+	}
+}
+
+func TestAlignRange(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		start time.Time
+		end   time.Time
+		step  time.Duration
+		wantS time.Time
+		wantE time.Time
+	}{
+		{
+			desc:  "aligned",
+			start: time.Unix(600, 0),
+			end:   time.Unix(1200, 0),
+			step:  time.Minute,
+			wantS: time.Unix(600, 0),
+			wantE: time.Unix(1200, 0),
+		},
+		{
+			desc:  "unaligned",
+			start: time.Unix(610, 0),
+			end:   time.Unix(1190, 500),
+			step:  time.Minute,
+			wantS: time.Unix(600, 0),
+			wantE: time.Unix(1200, 0),
+		},
+		{
+			desc:  "no step",
+			start: time.Unix(610, 0),
+			end:   time.Unix(1190, 0),
+			step:  0,
+			wantS: time.Unix(610, 0),
+			wantE: time.Unix(1190, 0),
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			start, end := alignRange(test.start, test.end, test.step)
+			if !start.Equal(test.wantS) {
+				t.Errorf("got start %s, want %s", start, test.wantS)
+			}
+
+			if !end.Equal(test.wantE) {
+				t.Errorf("got end %s, want %s", end, test.wantE)
+			}
+		})
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	for _, test := range []struct {
+		desc      string
+		rng       promv1.Range
+		maxPoints int
+		chunks    []promv1.Range
+	}{
+		{
+			desc: "single chunk",
+			rng: promv1.Range{
+				Start: time.Unix(0, 0),
+				End:   time.Unix(600, 0),
+				Step:  time.Minute,
+			},
+			maxPoints: 11,
+			chunks: []promv1.Range{
+				{
+					Start: time.Unix(0, 0),
+					End:   time.Unix(600, 0),
+					Step:  time.Minute,
+				},
+			},
+		},
+		{
+			desc: "multiple chunks",
+			rng: promv1.Range{
+				Start: time.Unix(0, 0),
+				End:   time.Unix(1200, 0),
+				Step:  time.Minute,
+			},
+			maxPoints: 10,
+			chunks: []promv1.Range{
+				{
+					Start: time.Unix(0, 0),
+					End:   time.Unix(540, 0),
+					Step:  time.Minute,
+				},
+				{
+					Start: time.Unix(600, 0),
+					End:   time.Unix(1140, 0),
+					Step:  time.Minute,
+				},
+				{
+					Start: time.Unix(1200, 0),
+					End:   time.Unix(1200, 0),
+					Step:  time.Minute,
+				},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			chunks := splitRange(test.rng, test.maxPoints)
+			if !reflect.DeepEqual(chunks, test.chunks) {
+				t.Errorf("got chunks %v, want %v", chunks, test.chunks)
+			}
+		})
+	}
+}
+
+func TestMergeMatrices(t *testing.T) {
+	metricA := model.Metric{"job": "a"}
+	metricB := model.Metric{"job": "b"}
+	parts := []model.Matrix{
+		{
+			{Metric: metricA, Values: []model.SamplePair{{Timestamp: 0, Value: 1}}},
+		},
+		{
+			{Metric: metricB, Values: []model.SamplePair{{Timestamp: 60000, Value: 3}}},
+			{Metric: metricA, Values: []model.SamplePair{{Timestamp: 60000, Value: 2}}},
+		},
+	}
+
+	want := model.Matrix{
+		{Metric: metricA, Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 60000, Value: 2}}},
+		{Metric: metricB, Values: []model.SamplePair{{Timestamp: 60000, Value: 3}}},
+	}
+
+	got := mergeMatrices(parts)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScrapeIntervalRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"yaml":"global:\n  scrape_interval: 1m\n"}}`)
+	}))
+	defer server.Close()
+
+	k := New(server.URL)
+	api, err := k.getAPI()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if interval := k.scrapeInterval(context.Background(), api); interval != 0 {
+		t.Errorf("got interval %s after error, wanted 0", interval)
+	}
+	if interval := k.scrapeInterval(context.Background(), api); interval != 0 || requests != 1 {
+		t.Errorf("got interval %s after %d requests, wanted cached failure", interval, requests)
+	}
+
+	entry := k.scrapeIntervals[server.URL]
+	entry.expires = time.Now().Add(-time.Second)
+	k.scrapeIntervals[server.URL] = entry
+
+	if interval := k.scrapeInterval(context.Background(), api); interval != time.Minute {
+		t.Errorf("got interval %s after retry, wanted %s", interval, time.Minute)
+	}
+	if interval := k.scrapeInterval(context.Background(), api); interval != time.Minute || requests != 2 {
+		t.Errorf("got interval %s after %d requests, wanted cached interval", interval, requests)
+	}
+}
@@ -0,0 +1,90 @@
+package kernel
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xperimental/ipromnb/promql"
+)
+
+// syntaxError is an error in a query, which points to its position in the code of the cell.
+type syntaxError struct {
+	Message string
+	Hint    string
+	// Line and Column of the error in the cell, both starting at one.
+	Line   int
+	Column int
+	// Source is the line of the cell containing the error.
+	Source string
+	// Width is the number of characters marked in Source.
+	Width int
+}
+
+// newSyntaxError converts a parse error of a query contained in the code of a cell.
+func newSyntaxError(code, query string, err *promql.ParseError) *syntaxError {
+	offset := strings.Index(code, query)
+	if open := strings.Index(code, "("); open >= 0 && query != code {
+		// Queries wrapped in commands start after the bracket of the command
+		if index := strings.Index(code[open+1:], query); index >= 0 {
+			offset = open + 1 + index
+		}
+	}
+	if offset < 0 {
+		code, offset = query, 0
+	}
+
+	start, end := offset+err.Pos.Start, offset+err.Pos.End
+	line, column := promql.LineColumn(code, start)
+
+	lineStart := strings.LastIndexByte(code[:start], '\n') + 1
+	lineEnd := strings.IndexByte(code[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(code)
+	} else {
+		lineEnd += start
+	}
+	if end > lineEnd {
+		end = lineEnd
+	}
+
+	width := utf8.RuneCountInString(code[start:end])
+	if width < 1 {
+		width = 1
+	}
+
+	return &syntaxError{
+		Message: err.Message,
+		Hint:    err.Hint,
+		Line:    line,
+		Column:  column,
+		Source:  code[lineStart:lineEnd],
+		Width:   width,
+	}
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Traceback returns the lines showing the error with a marker under the position of the error.
+func (e *syntaxError) Traceback() []string {
+	marker := &strings.Builder{}
+	for i, r := range []rune(e.Source) {
+		if i >= e.Column-1 {
+			break
+		}
+		if r == '\t' {
+			marker.WriteRune('\t')
+		} else {
+			marker.WriteRune(' ')
+		}
+	}
+	marker.WriteString(strings.Repeat("^", e.Width))
+
+	lines := []string{e.Error(), e.Source, marker.String()}
+	if e.Hint != "" {
+		lines = append(lines, "hint: "+e.Hint)
+	}
+	return lines
+}
@@ -0,0 +1,135 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xperimental/ipromnb/promql"
+)
+
+func TestSyntaxErrorTraceback(t *testing.T) {
+	for _, test := range []struct {
+		desc      string
+		code      string
+		query     string
+		traceback []string
+	}{
+		{
+			desc:  "query",
+			code:  "rate(http_requests_total)",
+			query: "rate(http_requests_total)",
+			traceback: []string{
+				`syntax error at line 1, column 6: expected type range vector in call to function "rate", got instant vector`,
+				"rate(http_requests_total)",
+				"     ^^^^^^^^^^^^^^^^^^^",
+				"hint: rate needs a range, for example rate(http_requests_total[5m])",
+			},
+		},
+		{
+			desc:  "command",
+			code:  "graph(\n  sum(up\n, unit=bytes)",
+			query: "sum(up",
+			traceback: []string{
+				`syntax error at line 2, column 9: unexpected end of input, expected ")"`,
+				"  sum(up",
+				"        ^",
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := promql.ParseExpr(test.query)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			traceback := newSyntaxError(test.code, test.query, err.(*promql.ParseError)).Traceback()
+			if !reflect.DeepEqual(traceback, test.traceback) {
+				t.Errorf("got %q, wanted %q", traceback, test.traceback)
+			}
+		})
+	}
+}
+
+// executeQuery executes code like a cell and returns the executed query.
+func executeQuery(k *Kernel, code string) (string, error) {
+	query, _, err := k.handleQuery(context.Background(), code, "", nil, nil, nil)
+	return query, err
+}
+
+func TestUnknownSyntaxSentToServer(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.FormValue("query"))
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[0,"1"]}]}}`)
+	}))
+	defer server.Close()
+
+	k := New(server.URL)
+	query, err := executeQuery(k, "histogram_count(rate(x[5m]))")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !reflect.DeepEqual(queries, []string{query}) || query != "histogram_count(rate(x[5m]))" {
+		t.Errorf("got queries %q, wanted the unchanged query", queries)
+	}
+
+	k.Options.Matchers = []*promql.LabelMatcher{{Name: "job", Type: promql.MatchEqual, Value: "node"}}
+	if _, err := executeQuery(k, "histogram_count(rate(x[5m]))"); err == nil {
+		t.Error("got no error, wanted syntax error when matchers need to be injected")
+	} else if _, ok := err.(*syntaxError); !ok {
+		t.Errorf("got error %q, wanted syntax error", err)
+	}
+
+	k.Options.Matchers = nil
+	queries = nil
+	if _, err := executeQuery(k, "sum(up"); err == nil {
+		t.Error("got no error, wanted syntax error for a query which is not a call of an unknown function")
+	} else if _, ok := err.(*syntaxError); !ok {
+		t.Errorf("got error %q, wanted syntax error", err)
+	}
+	if len(queries) != 0 {
+		t.Errorf("got queries %q, wanted none", queries)
+	}
+}
+
+func TestUnknownSyntaxRejected(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		server bool
+	}{
+		{
+			desc: "no server",
+		},
+		{
+			desc:   "rejected by server",
+			server: true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			k := New("")
+			if test.server {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"unknown function"}`)
+				}))
+				defer server.Close()
+				k = New(server.URL)
+			}
+
+			_, err := executeQuery(k, "rated(x[5m])")
+			if _, ok := err.(*syntaxError); !ok {
+				t.Errorf("got error %v, wanted syntax error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// instantTable is the result of an instant query prepared for display.
+type instantTable struct {
+	// Common contains the labels which have the same value in all rows.
+	Common model.Metric
+	// Columns contains the label names which are shown as columns.
+	Columns []model.LabelName
+	Rows    model.Vector
+	Time    time.Time
+	// Unit is used for formatting the values.
+	Unit string
+}
+
+func newInstantTable(vector model.Vector, sortBy string, instant time.Time) *instantTable {
+	rows := make(model.Vector, len(vector))
+	copy(rows, vector)
+	sortVector(rows, sortBy)
+
+	common := model.Metric{}
+	if len(rows) > 1 {
+		for name, value := range rows[0].Metric {
+			common[name] = value
+		}
+
+		for _, row := range rows[1:] {
+			for name, value := range common {
+				if row.Metric[name] != value {
+					delete(common, name)
+				}
+			}
+		}
+	}
+
+	names := map[model.LabelName]bool{}
+	for _, row := range rows {
+		for name := range row.Metric {
+			if _, ok := common[name]; !ok {
+				names[name] = true
+			}
+		}
+	}
+
+	columns := make([]model.LabelName, 0, len(names))
+	for name := range names {
+		columns = append(columns, name)
+	}
+	sortLabelNames(columns)
+
+	return &instantTable{
+		Common:  common,
+		Columns: columns,
+		Rows:    rows,
+		Time:    instant,
+	}
+}
+
+// sortLabelNames sorts label names alphabetically, but the metric name always comes first.
+func sortLabelNames(names []model.LabelName) {
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == model.MetricNameLabel || names[j] == model.MetricNameLabel {
+			return names[i] == model.MetricNameLabel
+		}
+		return names[i] < names[j]
+	})
+}
+
+// sortByValue sorts by the sample value. It can not be confused with a label name, because those can not contain a colon.
+const sortByValue = ":value"
+
+// sortVector sorts the vector in place. sortBy can either be sortByValue or a label name.
+// Prefixing it with "-" reverses the order. An empty sortBy keeps the order of the server.
+func sortVector(vector model.Vector, sortBy string) {
+	if sortBy == "" {
+		return
+	}
+
+	descending := strings.HasPrefix(sortBy, "-")
+	key := strings.TrimPrefix(sortBy, "-")
+
+	less := func(i, j int) bool {
+		return vector[i].Metric[model.LabelName(key)] < vector[j].Metric[model.LabelName(key)]
+	}
+	if key == sortByValue {
+		less = func(i, j int) bool {
+			a, b := float64(vector[i].Value), float64(vector[j].Value)
+			// NaN is treated as the smallest value
+			return a < b || (math.IsNaN(a) && !math.IsNaN(b))
+		}
+	}
+
+	sort.SliceStable(vector, func(i, j int) bool {
+		if descending {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+// formatValue formats a sample value without losing precision.
+// Scientific notation is only used for very large or very small values.
+func formatValue(v model.SampleValue) string {
+	f := float64(v)
+	abs := math.Abs(f)
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, 0):
+		return v.String()
+	case abs != 0 && (abs < 1e-4 || abs >= 1e15):
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	default:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+}
+
+func formatCommonLabels(common model.Metric) string {
+	names := make([]model.LabelName, 0, len(common))
+	for name := range common {
+		names = append(names, name)
+	}
+	sortLabelNames(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%q", name, common[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func columnTitle(name model.LabelName) string {
+	if name == model.MetricNameLabel {
+		return "Metric"
+	}
+	return string(name)
+}
+
+// HTML renders the table as HTML.
+func (t *instantTable) HTML(loc *time.Location) string {
+	output := &bytes.Buffer{}
+	fmt.Fprintf(output, "<p>Evaluated at %s</p>\n", html.EscapeString(t.Time.In(loc).Format(time.RFC3339)))
+	if len(t.Common) > 0 {
+		fmt.Fprintf(output, "<p>Common labels: <code>%s</code></p>\n", html.EscapeString(formatCommonLabels(t.Common)))
+	}
+
+	fmt.Fprint(output, "<table><thead><tr>")
+	for _, name := range t.Columns {
+		fmt.Fprintf(output, "<th>%s</th>", html.EscapeString(columnTitle(name)))
+	}
+	fmt.Fprintln(output, "<th>Value</th></tr></thead><tbody>")
+	for _, row := range t.Rows {
+		fmt.Fprint(output, "<tr>")
+		for _, name := range t.Columns {
+			fmt.Fprintf(output, "<td>%s</td>", html.EscapeString(string(row.Metric[name])))
+		}
+		fmt.Fprintf(output, "<td title=\"%s\">%s</td></tr>\n",
+			html.EscapeString(formatValue(row.Value)), html.EscapeString(formatUnit(float64(row.Value), t.Unit)))
+	}
+	fmt.Fprint(output, "</tbody></table>")
+
+	return output.String()
+}
+
+// Text renders the table as plain text.
+func (t *instantTable) Text(loc *time.Location) string {
+	output := &bytes.Buffer{}
+	fmt.Fprintf(output, "Evaluated at %s\n", t.Time.In(loc).Format(time.RFC3339))
+	if len(t.Common) > 0 {
+		fmt.Fprintf(output, "Common labels: %s\n", formatCommonLabels(t.Common))
+	}
+
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, name := range t.Columns {
+		fmt.Fprintf(w, "%s\t", columnTitle(name))
+	}
+	fmt.Fprintln(w, "Value")
+	for _, row := range t.Rows {
+		for _, name := range t.Columns {
+			fmt.Fprintf(w, "%s\t", row.Metric[name])
+		}
+		fmt.Fprintln(w, formatUnit(float64(row.Value), t.Unit))
+	}
+	w.Flush()
+
+	return output.String()
+}
+
+// JSON returns the table in the same format as the Prometheus API.
+func (t *instantTable) JSON() interface{} {
+	return map[string]interface{}{
+		"resultType": model.ValVector.String(),
+		"result":     t.Rows,
+	}
+}
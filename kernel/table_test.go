@@ -0,0 +1,115 @@
+package kernel
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestFormatValue(t *testing.T) {
+	for _, test := range []struct {
+		value model.SampleValue
+		want  string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{0.25, "0.25"},
+		{0.000123, "0.000123"},
+		{0.0000012, "1.2e-06"},
+		{1600000000, "1600000000"},
+		{-42.5, "-42.5"},
+		{1e20, "1e+20"},
+		{model.SampleValue(math.NaN()), "NaN"},
+		{model.SampleValue(math.Inf(1)), "+Inf"},
+	} {
+		if got := formatValue(test.value); got != test.want {
+			t.Errorf("formatValue(%v): got %q, want %q", float64(test.value), got, test.want)
+		}
+	}
+}
+
+func TestSortVector(t *testing.T) {
+	vector := model.Vector{
+		{Metric: model.Metric{"job": "b", "value": "z"}, Value: 2},
+		{Metric: model.Metric{"job": "c", "value": "x"}, Value: model.SampleValue(math.NaN())},
+		{Metric: model.Metric{"job": "a", "value": "y"}, Value: 3},
+	}
+
+	for _, test := range []struct {
+		sortBy string
+		jobs   []string
+	}{
+		{"", []string{"b", "c", "a"}},
+		{":value", []string{"c", "b", "a"}},
+		{"-:value", []string{"a", "b", "c"}},
+		{"value", []string{"c", "a", "b"}},
+		{"job", []string{"a", "b", "c"}},
+		{"-job", []string{"c", "b", "a"}},
+	} {
+		test := test
+		t.Run(test.sortBy, func(t *testing.T) {
+			t.Parallel()
+
+			sorted := make(model.Vector, len(vector))
+			copy(sorted, vector)
+			sortVector(sorted, test.sortBy)
+
+			jobs := []string{}
+			for _, s := range sorted {
+				jobs = append(jobs, string(s.Metric["job"]))
+			}
+
+			if !reflect.DeepEqual(jobs, test.jobs) {
+				t.Errorf("got %v, want %v", jobs, test.jobs)
+			}
+		})
+	}
+}
+
+func TestInstantTable(t *testing.T) {
+	vector := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "node", "instance": "a<1>"}, Value: 1},
+		{Metric: model.Metric{"__name__": "up", "job": "node", "instance": "b"}, Value: 0},
+	}
+
+	table := newInstantTable(vector, "", time.Unix(0, 0))
+
+	wantCommon := model.Metric{"__name__": "up", "job": "node"}
+	if !reflect.DeepEqual(table.Common, wantCommon) {
+		t.Errorf("got common labels %v, want %v", table.Common, wantCommon)
+	}
+
+	wantColumns := []model.LabelName{"instance"}
+	if !reflect.DeepEqual(table.Columns, wantColumns) {
+		t.Errorf("got columns %v, want %v", table.Columns, wantColumns)
+	}
+
+	html := table.HTML(time.UTC)
+	if !strings.Contains(html, "<td>a&lt;1&gt;</td>") {
+		t.Errorf("label value not escaped: %s", html)
+	}
+
+	if !strings.Contains(html, "__name__=&#34;up&#34;, job=&#34;node&#34;") {
+		t.Errorf("common labels missing: %s", html)
+	}
+
+	text := table.Text(time.UTC)
+	wantText := "Evaluated at 1970-01-01T00:00:00Z\nCommon labels: __name__=\"up\", job=\"node\"\ninstance  Value\na<1>      1\nb         0\n"
+	if text != wantText {
+		t.Errorf("got text %q, want %q", text, wantText)
+	}
+}
+
+func TestSortLabelNames(t *testing.T) {
+	names := []model.LabelName{"job", "__name__", "instance"}
+	sortLabelNames(names)
+
+	want := []model.LabelName{"__name__", "instance", "job"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
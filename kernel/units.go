@@ -0,0 +1,226 @@
+package kernel
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gonum.org/v1/plot"
+)
+
+const (
+	unitAuto        = "auto"
+	unitNone        = "none"
+	unitBytes       = "bytes"
+	unitBytesPerSec = "bytes/s"
+	unitSeconds     = "seconds"
+	unitPercent     = "percent"
+	unitPercentUnit = "percentunit"
+	unitSI          = "si"
+)
+
+var validUnits = []string{
+	unitAuto,
+	unitNone,
+	unitBytes,
+	unitBytesPerSec,
+	unitSeconds,
+	unitPercent,
+	unitPercentUnit,
+	unitSI,
+}
+
+type unitPrefix struct {
+	factor float64
+	suffix string
+}
+
+var (
+	iecPrefixes = []unitPrefix{
+		{1 << 60, "EiB"},
+		{1 << 50, "PiB"},
+		{1 << 40, "TiB"},
+		{1 << 30, "GiB"},
+		{1 << 20, "MiB"},
+		{1 << 10, "KiB"},
+		{1, "B"},
+	}
+	siPrefixes = []unitPrefix{
+		{1e18, "E"},
+		{1e15, "P"},
+		{1e12, "T"},
+		{1e9, "G"},
+		{1e6, "M"},
+		{1e3, "k"},
+		{1, ""},
+		{1e-3, "m"},
+		{1e-6, "µ"},
+		{1e-9, "n"},
+	}
+	timePrefixes = []unitPrefix{
+		{24 * 60 * 60, "d"},
+		{60 * 60, "h"},
+		{60, "min"},
+		{1, "s"},
+		{1e-3, "ms"},
+		{1e-6, "µs"},
+		{1e-9, "ns"},
+	}
+)
+
+// formatUnit formats a value using the given unit, for example "1.5 GiB" or "230 ms".
+func formatUnit(v float64, unit string) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return formatValue(model.SampleValue(v))
+	}
+
+	switch unit {
+	case unitBytes:
+		return formatPrefixed(v, iecPrefixes)
+	case unitBytesPerSec:
+		return formatPrefixed(v, iecPrefixes) + "/s"
+	case unitSeconds:
+		return formatPrefixed(v, timePrefixes)
+	case unitPercent:
+		return formatScaled(v) + "%"
+	case unitPercentUnit:
+		return formatScaled(v*100) + "%"
+	case unitSI:
+		return formatPrefixed(v, siPrefixes)
+	default:
+		return formatValue(model.SampleValue(v))
+	}
+}
+
+// formatPrefixed selects the largest prefix smaller than the value and formats the scaled value.
+func formatPrefixed(v float64, prefixes []unitPrefix) string {
+	abs := math.Abs(v)
+	prefix := prefixes[len(prefixes)-1]
+	for _, p := range prefixes {
+		if abs >= p.factor || (abs == 0 && p.factor == 1) {
+			prefix = p
+			break
+		}
+	}
+
+	return strings.TrimSpace(formatScaled(v/prefix.factor) + " " + prefix.suffix)
+}
+
+// formatScaled formats a value with about three significant digits.
+func formatScaled(v float64) string {
+	abs := math.Abs(v)
+	decimals := 2
+	switch {
+	case abs >= 100:
+		decimals = 0
+	case abs >= 10:
+		decimals = 1
+	}
+
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+var (
+	metricNameRegex = regexp.MustCompile(`[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	rateRegex       = regexp.MustCompile(`\b(rate|irate|deriv)\s*\(`)
+	// Quantiles and averages calculated from rates keep the unit of the metric.
+	keepUnitRegex = regexp.MustCompile(`\bhistogram_quantile\s*\(|_count\b`)
+)
+
+// detectUnit guesses the unit of a query result based on the suffixes of the metric names.
+// Returns unitNone if no unit could be detected.
+func detectUnit(query string, names []string) string {
+	candidates := append([]string{}, names...)
+	candidates = append(candidates, metricNameRegex.FindAllString(query, -1)...)
+	perSecond := rateRegex.MatchString(query) && !keepUnitRegex.MatchString(query)
+
+	for _, name := range candidates {
+		name = strings.TrimSuffix(name, "_bucket")
+		name = strings.TrimSuffix(name, "_sum")
+		switch {
+		case strings.HasSuffix(name, "_bytes"), strings.HasSuffix(name, "_bytes_total"):
+			if perSecond {
+				return unitBytesPerSec
+			}
+			return unitBytes
+		case strings.HasSuffix(name, "_seconds"), strings.HasSuffix(name, "_seconds_total"):
+			if perSecond {
+				// Seconds per second is a ratio
+				return unitNone
+			}
+			return unitSeconds
+		case strings.HasSuffix(name, "_ratio"):
+			return unitPercentUnit
+		case strings.HasSuffix(name, "_percent"):
+			return unitPercent
+		}
+	}
+
+	return unitNone
+}
+
+func setUnit(v *string, value string) error {
+	unit := strings.ToLower(value)
+	if !containsString(validUnits, unit) {
+		return fmt.Errorf("not a valid unit: %s (valid units: %s)", value, strings.Join(validUnits, ", "))
+	}
+
+	*v = unit
+	return nil
+}
+
+// resultUnit returns the unit which should be used for displaying the result of a query.
+func resultUnit(unit, query string, names []string) string {
+	if unit == "" || unit == unitAuto {
+		return detectUnit(query, names)
+	}
+	return unit
+}
+
+// unitTicks formats the tick labels of an axis using a unit.
+type unitTicks struct {
+	Unit string
+}
+
+var _ plot.Ticker = unitTicks{}
+
+// Ticks implements plot.Ticker.
+func (t unitTicks) Ticks(min, max float64) []plot.Tick {
+	ticks := plot.DefaultTicks{}.Ticks(min, max)
+	if t.Unit == "" || t.Unit == unitNone {
+		return ticks
+	}
+
+	// Tick values like 0.30000000000000004 are rounded to the precision of the step between labels.
+	decimals := 0
+	prev := math.NaN()
+	for _, tick := range ticks {
+		if tick.Label == "" {
+			continue
+		}
+		if !math.IsNaN(prev) {
+			decimals = int(math.Max(0, 1-math.Floor(math.Log10(tick.Value-prev))))
+			break
+		}
+		prev = tick.Value
+	}
+
+	scale := math.Pow(10, float64(decimals))
+	for i := range ticks {
+		if ticks[i].Label == "" {
+			continue
+		}
+		ticks[i].Label = formatUnit(math.Round(ticks[i].Value*scale)/scale, t.Unit)
+	}
+	return ticks
+}
@@ -0,0 +1,117 @@
+package kernel
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFormatUnit(t *testing.T) {
+	for _, test := range []struct {
+		value float64
+		unit  string
+		want  string
+	}{
+		{1.5 * (1 << 30), unitBytes, "1.5 GiB"},
+		{512, unitBytes, "512 B"},
+		{0, unitBytes, "0 B"},
+		{2048, unitBytesPerSec, "2 KiB/s"},
+		{0.23, unitSeconds, "230 ms"},
+		{90, unitSeconds, "1.5 min"},
+		{0.0000015, unitSeconds, "1.5 µs"},
+		{0, unitSeconds, "0 s"},
+		{42.123, unitPercent, "42.1%"},
+		{0.5, unitPercentUnit, "50%"},
+		{1600000000, unitSI, "1.6 G"},
+		{12, unitSI, "12"},
+		{-1500, unitSI, "-1.5 k"},
+		{1600000000, unitNone, "1600000000"},
+		{math.NaN(), unitBytes, "NaN"},
+	} {
+		if got := formatUnit(test.value, test.unit); got != test.want {
+			t.Errorf("formatUnit(%v, %q): got %q, want %q", test.value, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestDetectUnit(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		query string
+		names []string
+		unit  string
+	}{
+		{
+			desc:  "bytes gauge",
+			query: "node_memory_MemFree_bytes",
+			unit:  unitBytes,
+		},
+		{
+			desc:  "bytes rate",
+			query: "rate(node_network_receive_bytes_total[5m])",
+			unit:  unitBytesPerSec,
+		},
+		{
+			desc:  "seconds",
+			query: "up",
+			names: []string{"scrape_duration_seconds"},
+			unit:  unitSeconds,
+		},
+		{
+			desc:  "cpu usage",
+			query: "rate(process_cpu_seconds_total[5m])",
+			unit:  unitNone,
+		},
+		{
+			desc:  "histogram quantile",
+			query: "histogram_quantile(0.9, rate(http_request_duration_seconds_bucket[5m]))",
+			unit:  unitSeconds,
+		},
+		{
+			desc:  "average",
+			query: "rate(http_request_duration_seconds_sum[5m]) / rate(http_request_duration_seconds_count[5m])",
+			unit:  unitSeconds,
+		},
+		{
+			desc:  "ratio",
+			query: "cache_hit_ratio",
+			unit:  unitPercentUnit,
+		},
+		{
+			desc:  "unknown",
+			query: "up",
+			unit:  unitNone,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			unit := detectUnit(test.query, test.names)
+			if unit != test.unit {
+				t.Errorf("got unit %q, want %q", unit, test.unit)
+			}
+		})
+	}
+}
+
+func TestUnitTicks(t *testing.T) {
+	for _, test := range []struct {
+		unit string
+		want []string
+	}{
+		{unitNone, []string{"0.0", "0.3", "0.6"}},
+		{unitPercentUnit, []string{"0%", "30%", "60%"}},
+		{unitSI, []string{"0", "300 m", "600 m"}},
+	} {
+		var labels []string
+		for _, tick := range (unitTicks{Unit: test.unit}).Ticks(0, 0.7) {
+			if tick.Label != "" {
+				labels = append(labels, tick.Label)
+			}
+		}
+		if !reflect.DeepEqual(labels, test.want) {
+			t.Errorf("unit %q: got labels %q, want %q", test.unit, labels, test.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package kernel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xperimental/ipromnb/promql"
+	"github.com/xperimental/ipromnb/scaffold"
+)
+
+const (
+	defaultWatchEvery    = 30 * time.Second
+	defaultWatchDuration = time.Hour
+	// minWatchEvery is the shortest interval in which watched queries are refreshed.
+	minWatchEvery = 5 * time.Second
+)
+
+// watch is a graph which is refreshed in the background.
+type watch struct {
+	// Cell is the ID of the cell containing the watch, it is empty if the frontend does not send cell IDs.
+	Cell string
+	// Code is the code of the cell, which identifies the cell if there is no ID.
+	Code   string
+	cancel func()
+	done   chan struct{}
+}
+
+// inCell returns true if the watch was started by the cell with the given ID or code.
+func (w *watch) inCell(cell, code string) bool {
+	if cell != "" {
+		return w.Cell == cell
+	}
+	return w.Cell == "" && w.Code == code
+}
+
+// watchArgs returns the refresh interval and the duration of a watch command.
+func watchArgs(cmd *command) (every, duration time.Duration, err error) {
+	every, duration = defaultWatchEvery, defaultWatchDuration
+	if value, ok := cmd.Args["every"]; ok {
+		if every, err = promql.ParseDuration(value); err != nil {
+			return 0, 0, err
+		}
+		if every < minWatchEvery {
+			return 0, 0, fmt.Errorf("every needs to be at least %s: %s", promql.FormatDuration(minWatchEvery), value)
+		}
+	}
+	if value, ok := cmd.Args["for"]; ok {
+		if duration, err = promql.ParseDuration(value); err != nil {
+			return 0, 0, err
+		}
+	}
+	return every, duration, nil
+}
+
+// handleWatch shows a graph of the query and starts refreshing it in the background.
+// The graph always shows the length of the configured time range, ending at the time of the refresh.
+func (k *Kernel) handleWatch(ctx context.Context, cmd *command, cell, code, unit string, displayData scaffold.DisplayFunc) (*scaffold.DisplayData, error) {
+	every, duration, err := watchArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without cell IDs an edited cell can not be recognised, so only one of these watches runs at a time.
+	if cell == "" {
+		k.stopWatches(func(w *watch) bool {
+			return w.Cell == ""
+		})
+	}
+
+	// The watch runs in parallel with executions, which can change the options of the kernel.
+	snapshot := New(k.Options.Server)
+	snapshot.Options = k.Options
+	window := k.Options.TimeEnd.Sub(k.Options.TimeStart)
+	displayID := newDisplayID()
+
+	data, err := snapshot.watchGraph(ctx, cmd.Query, window, unit, displayID)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	w := &watch{
+		Cell:   cell,
+		Code:   code,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	k.watchMutex.Lock()
+	k.watches[w] = true
+	k.watchMutex.Unlock()
+
+	go func() {
+		defer close(w.done)
+		defer k.removeWatch(w)
+
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		stop := time.After(duration)
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			data, err := snapshot.watchGraph(watchCtx, cmd.Query, window, unit, displayID)
+			if watchCtx.Err() != nil {
+				return
+			}
+			if err != nil {
+				data = &scaffold.DisplayData{
+					Data: map[string]interface{}{
+						"text/plain": fmt.Sprintf("Error executing query: %s", err),
+					},
+					Transient: map[string]interface{}{
+						"display_id": displayID,
+					},
+				}
+			}
+			displayData(data, true)
+		}
+	}()
+
+	return data, nil
+}
+
+// watchGraph renders the graph of a watch ending now.
+func (k *Kernel) watchGraph(ctx context.Context, query string, window time.Duration, unit, displayID string) (*scaffold.DisplayData, error) {
+	end := k.Options.NowFunc()
+	result, err := k.handleRangeQuery(ctx, query, end.Add(-window), end, false, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scaffold.DisplayData{
+		Data: map[string]interface{}{
+			"image/png":  result,
+			"text/plain": fmt.Sprintf("Graph of %s, updated at %s", query, end.In(k.Options.TimeZone()).Format(time.RFC3339)),
+		},
+		Transient: map[string]interface{}{
+			"display_id": displayID,
+		},
+	}, nil
+}
+
+func (k *Kernel) removeWatch(w *watch) {
+	k.watchMutex.Lock()
+	defer k.watchMutex.Unlock()
+	delete(k.watches, w)
+}
+
+// stopWatches stops the watches for which stop returns true and waits until they have ended.
+func (k *Kernel) stopWatches(stop func(w *watch) bool) {
+	var stopped []*watch
+	k.watchMutex.Lock()
+	for w := range k.watches {
+		if stop(w) {
+			stopped = append(stopped, w)
+		}
+	}
+	k.watchMutex.Unlock()
+
+	for _, w := range stopped {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// HandleInterrupt implements scaffold.InterruptHandler, it stops all watches.
+func (k *Kernel) HandleInterrupt() {
+	k.stopWatches(func(*watch) bool {
+		return true
+	})
+}
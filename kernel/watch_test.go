@@ -0,0 +1,147 @@
+package kernel
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatchArgs(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		args     map[string]string
+		every    time.Duration
+		duration time.Duration
+		err      bool
+	}{
+		{
+			desc:     "defaults",
+			args:     map[string]string{},
+			every:    defaultWatchEvery,
+			duration: defaultWatchDuration,
+		},
+		{
+			desc:     "custom",
+			args:     map[string]string{"every": "1m", "for": "1h30m"},
+			every:    time.Minute,
+			duration: 90 * time.Minute,
+		},
+		{
+			desc: "too fast",
+			args: map[string]string{"every": "1s"},
+			err:  true,
+		},
+		{
+			desc: "invalid duration",
+			args: map[string]string{"for": "forever"},
+			err:  true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			every, duration, err := watchArgs(&command{Name: "watch", Query: "up", Args: test.args})
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, wanted error %v", err, test.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if every != test.every {
+				t.Errorf("got every %s, wanted %s", every, test.every)
+			}
+			if duration != test.duration {
+				t.Errorf("got duration %s, wanted %s", duration, test.duration)
+			}
+		})
+	}
+}
+
+func TestStopWatches(t *testing.T) {
+	k := New("")
+	start := func(cell string) *watch {
+		ctx, cancel := context.WithCancel(context.Background())
+		w := &watch{
+			Cell:   cell,
+			cancel: cancel,
+			done:   make(chan struct{}),
+		}
+		k.watches[w] = true
+		go func() {
+			defer close(w.done)
+			defer k.removeWatch(w)
+			<-ctx.Done()
+		}()
+		return w
+	}
+
+	a := start("a")
+	start("b")
+
+	k.stopWatches(func(w *watch) bool {
+		return w.Cell == "a"
+	})
+	if _, ok := k.watches[a]; ok || len(k.watches) != 1 {
+		t.Errorf("got %d watches, wanted only watch b", len(k.watches))
+	}
+
+	k.HandleInterrupt()
+	if len(k.watches) != 0 {
+		t.Errorf("got %d watches after interrupt, wanted none", len(k.watches))
+	}
+}
+
+func TestWatchInCell(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		watch watch
+		cell  string
+		code  string
+		out   bool
+	}{
+		{
+			desc:  "same cell ID",
+			watch: watch{Cell: "a", Code: "watch(up)"},
+			cell:  "a",
+			code:  "watch(up, every=1m)",
+			out:   true,
+		},
+		{
+			desc:  "other cell ID",
+			watch: watch{Cell: "a", Code: "watch(up)"},
+			cell:  "b",
+			code:  "watch(up)",
+			out:   false,
+		},
+		{
+			desc:  "same code without cell ID",
+			watch: watch{Code: "watch(up)"},
+			code:  "watch(up)",
+			out:   true,
+		},
+		{
+			desc:  "other code without cell ID",
+			watch: watch{Code: "watch(up)"},
+			code:  "watch(up, every=1m)",
+			out:   false,
+		},
+		{
+			desc:  "cell ID only on watch",
+			watch: watch{Cell: "a", Code: "watch(up)"},
+			code:  "watch(up)",
+			out:   false,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			if out := test.watch.inCell(test.cell, test.code); out != test.out {
+				t.Errorf("got %v, wanted %v", out, test.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// CodeMirror mode for PromQL and the commands of the Prometheus kernel.
+// Jupyter loads this file from the directory of the kernelspec.
+define(["codemirror/lib/codemirror"], function (CodeMirror) {
+    "use strict";
+
+    var functions = [
+        "abs", "absent", "absent_over_time", "acos", "acosh", "asin", "asinh", "atan", "atanh",
+        "avg_over_time", "ceil", "changes", "clamp", "clamp_max", "clamp_min", "cos", "cosh",
+        "count_over_time", "day_of_month", "day_of_week", "day_of_year", "days_in_month", "deg",
+        "delta", "deriv", "exp", "floor", "histogram_quantile", "holt_winters", "hour", "idelta",
+        "increase", "irate", "label_join", "label_replace", "last_over_time", "ln", "log10",
+        "log2", "max_over_time", "min_over_time", "minute", "month", "pi", "predict_linear",
+        "present_over_time", "quantile_over_time", "rad", "rate", "resets", "round", "scalar",
+        "sgn", "sin", "sinh", "sort", "sort_desc", "sqrt", "stddev_over_time", "stdvar_over_time",
+        "sum_over_time", "tan", "tanh", "time", "timestamp", "vector", "year"
+    ];
+    var aggregations = [
+        "avg", "bottomk", "count", "count_values", "group", "max", "min", "quantile", "stddev",
+        "stdvar", "sum", "topk"
+    ];
+    var keywords = [
+        "and", "or", "unless", "atan2", "by", "without", "on", "ignoring",
+        "group_left", "group_right", "bool", "offset"
+    ];
+    var commands = ["graph", "graph0", "table", "format", "explain", "watch", "history"];
+
+    function wordSet(words) {
+        var set = {};
+        for (var i = 0; i < words.length; i++) {
+            set[words[i]] = true;
+        }
+        return set;
+    }
+
+    var functionSet = wordSet(functions);
+    var aggregationSet = wordSet(aggregations);
+    var keywordSet = wordSet(keywords);
+    var commandSet = wordSet(commands);
+
+    function tokenString(quote) {
+        return function (stream, state) {
+            var escaped = false, ch;
+            while ((ch = stream.next()) != null) {
+                if (ch === quote && !escaped) {
+                    state.tokenize = null;
+                    break;
+                }
+                escaped = !escaped && ch === "\\" && quote !== "`";
+            }
+            return "string";
+        };
+    }
+
+    function defineMode() {
+        return {
+            startState: function () {
+                return {tokenize: null, braces: 0, option: false, atStart: true};
+            },
+            token: function (stream, state) {
+                if (stream.sol()) {
+                    state.option = false;
+                }
+                if (state.tokenize) {
+                    return state.tokenize(stream, state);
+                }
+                if (stream.eatSpace()) {
+                    return null;
+                }
+
+                var atStart = state.atStart;
+                state.atStart = false;
+
+                if (state.option) {
+                    stream.skipToEnd();
+                    return "string";
+                }
+                if (stream.sol() && stream.match(/^@\s*[a-zA-Z]+/)) {
+                    // Kernel options like @server=http://prometheus:9090
+                    state.option = true;
+                    stream.eat("=");
+                    return "meta";
+                }
+
+                var ch = stream.next();
+                if (ch === "#") {
+                    stream.skipToEnd();
+                    return "comment";
+                }
+                if (ch === "\"" || ch === "'" || ch === "`") {
+                    state.tokenize = tokenString(ch);
+                    return state.tokenize(stream, state);
+                }
+                if (/[0-9.]/.test(ch)) {
+                    stream.backUp(1);
+                    stream.match(/^([0-9]+(ms|[smhdwy]))+/) ||
+                        stream.match(/^(0[xX][0-9a-fA-F]+|[0-9]*\.?[0-9]+([eE][+-]?[0-9]+)?)/) ||
+                        stream.next();
+                    return "number";
+                }
+                if (ch === "{") {
+                    state.braces++;
+                    return "bracket";
+                }
+                if (ch === "}") {
+                    state.braces = Math.max(0, state.braces - 1);
+                    return "bracket";
+                }
+                if (/[()\[\],]/.test(ch)) {
+                    return "bracket";
+                }
+                if (/[-+*\/%^=!<>~@]/.test(ch)) {
+                    stream.eatWhile(/[=~]/);
+                    return "operator";
+                }
+                if (/[a-zA-Z_:]/.test(ch)) {
+                    stream.eatWhile(/[a-zA-Z0-9_:]/);
+                    var word = stream.current();
+                    if (state.braces > 0) {
+                        return "attribute";
+                    }
+                    var call = stream.match(/^\s*\(/, false);
+                    if (atStart && call && commandSet.hasOwnProperty(word)) {
+                        return "def";
+                    }
+                    if (keywordSet.hasOwnProperty(word.toLowerCase())) {
+                        return "keyword";
+                    }
+                    if (aggregationSet.hasOwnProperty(word) || (call && functionSet.hasOwnProperty(word))) {
+                        return "builtin";
+                    }
+                    if (/^(inf|nan)$/i.test(word)) {
+                        return "number";
+                    }
+                    return "variable";
+                }
+                return null;
+            },
+            lineComment: "#"
+        };
+    }
+
+    return {
+        onload: function () {
+            CodeMirror.defineMode("promql", defineMode);
+            CodeMirror.defineMIME("text/x-promql", "promql");
+        }
+    };
+});
@@ -0,0 +1,236 @@
+package promql
+
+import (
+	"time"
+)
+
+// PositionRange describes the position of a node in the input as byte offsets.
+type PositionRange struct {
+	Start int
+	End   int
+}
+
+// Expr is a node of the syntax tree of a PromQL expression.
+type Expr interface {
+	// Type returns the type the expression evaluates to.
+	Type() ValueType
+	// Position returns the position of the expression in the input.
+	Position() PositionRange
+}
+
+// MatchType is the operator of a label matcher.
+type MatchType string
+
+// Operators of label matchers.
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// LabelMatcher is a single matcher of a vector selector, for example job="prometheus".
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// NumberLiteral is a number like 1.5 or Inf.
+type NumberLiteral struct {
+	Val float64
+	// Text is the number as it was written.
+	Text string
+	Pos  PositionRange
+}
+
+// StringLiteral is a quoted string.
+type StringLiteral struct {
+	Val string
+	Pos PositionRange
+}
+
+// VectorSelector selects series by their metric name and labels.
+type VectorSelector struct {
+	Name     string
+	Matchers []*LabelMatcher
+	Offset   time.Duration
+	// At contains the argument of the @ modifier, for example "1609746000" or "end()".
+	At  string
+	Pos PositionRange
+}
+
+// MatrixSelector selects a range of samples, for example http_requests_total[5m].
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+	Offset time.Duration
+	At     string
+	Pos    PositionRange
+}
+
+// SubqueryExpr evaluates an instant vector expression over a range, for example rate(x[5m])[1h:1m].
+type SubqueryExpr struct {
+	Expr   Expr
+	Range  time.Duration
+	Step   time.Duration
+	Offset time.Duration
+	At     string
+	Pos    PositionRange
+}
+
+// Call is a call of a function.
+type Call struct {
+	Func *Function
+	Args []Expr
+	Pos  PositionRange
+}
+
+// AggregateExpr is an aggregation like sum by (job) (x).
+type AggregateExpr struct {
+	Op string
+	// Param is the parameter of aggregations like topk, otherwise nil.
+	Param    Expr
+	Expr     Expr
+	Grouping []string
+	Without  bool
+	Pos      PositionRange
+}
+
+// Cardinalities of vector matching.
+const (
+	CardOneToOne  = "one-to-one"
+	CardManyToOne = "many-to-one"
+	CardOneToMany = "one-to-many"
+)
+
+// VectorMatching describes how the series of a binary expression between vectors are matched.
+type VectorMatching struct {
+	Card string
+	// On is true if the labels are given using on, false if ignoring was used.
+	On     bool
+	Labels []string
+	// Include contains the labels of group_left or group_right.
+	Include []string
+}
+
+// BinaryExpr is a binary operation like a + b.
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+	// Matching is nil if no vector matching is given.
+	Matching   *VectorMatching
+	ReturnBool bool
+	Pos        PositionRange
+}
+
+// UnaryExpr is a negated expression.
+type UnaryExpr struct {
+	Op   string
+	Expr Expr
+	Pos  PositionRange
+}
+
+// ParenExpr is an expression in parentheses.
+type ParenExpr struct {
+	Expr Expr
+	Pos  PositionRange
+}
+
+// Type implements Expr.
+func (e *NumberLiteral) Type() ValueType { return ValueTypeScalar }
+
+// Type implements Expr.
+func (e *StringLiteral) Type() ValueType { return ValueTypeString }
+
+// Type implements Expr.
+func (e *VectorSelector) Type() ValueType { return ValueTypeVector }
+
+// Type implements Expr.
+func (e *MatrixSelector) Type() ValueType { return ValueTypeMatrix }
+
+// Type implements Expr.
+func (e *SubqueryExpr) Type() ValueType { return ValueTypeMatrix }
+
+// Type implements Expr.
+func (e *Call) Type() ValueType { return e.Func.ReturnType }
+
+// Type implements Expr.
+func (e *AggregateExpr) Type() ValueType { return ValueTypeVector }
+
+// Type implements Expr.
+func (e *BinaryExpr) Type() ValueType {
+	if e.LHS.Type() == ValueTypeScalar && e.RHS.Type() == ValueTypeScalar {
+		return ValueTypeScalar
+	}
+	return ValueTypeVector
+}
+
+// Type implements Expr.
+func (e *UnaryExpr) Type() ValueType { return e.Expr.Type() }
+
+// Type implements Expr.
+func (e *ParenExpr) Type() ValueType { return e.Expr.Type() }
+
+// Position implements Expr.
+func (e *NumberLiteral) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *StringLiteral) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *VectorSelector) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *MatrixSelector) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *SubqueryExpr) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *Call) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *AggregateExpr) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *BinaryExpr) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *UnaryExpr) Position() PositionRange { return e.Pos }
+
+// Position implements Expr.
+func (e *ParenExpr) Position() PositionRange { return e.Pos }
+
+// Children returns the direct sub-expressions of an expression.
+func Children(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *MatrixSelector:
+		return []Expr{e.Vector}
+	case *SubqueryExpr:
+		return []Expr{e.Expr}
+	case *Call:
+		return e.Args
+	case *AggregateExpr:
+		if e.Param != nil {
+			return []Expr{e.Param, e.Expr}
+		}
+		return []Expr{e.Expr}
+	case *BinaryExpr:
+		return []Expr{e.LHS, e.RHS}
+	case *UnaryExpr:
+		return []Expr{e.Expr}
+	case *ParenExpr:
+		return []Expr{e.Expr}
+	}
+	return nil
+}
+
+// Walk calls f for the expression and all of its sub-expressions, parents first.
+func Walk(expr Expr, f func(Expr)) {
+	f(expr)
+	for _, child := range Children(expr) {
+		Walk(child, f)
+	}
+}
@@ -0,0 +1,88 @@
+package promql
+
+// FunctionDocs contains a short description of every function.
+var FunctionDocs = map[string]string{
+	"abs":                "Returns the input vector with all sample values converted to their absolute value.",
+	"absent":             "Returns a 1-element vector with the value 1 if the vector passed to it has no elements. Useful for alerting on missing series.",
+	"absent_over_time":   "Returns a 1-element vector with the value 1 if the range vector passed to it has no elements.",
+	"acos":               "Calculates the arccosine of all elements.",
+	"acosh":              "Calculates the inverse hyperbolic cosine of all elements.",
+	"asin":               "Calculates the arcsine of all elements.",
+	"asinh":              "Calculates the inverse hyperbolic sine of all elements.",
+	"atan":               "Calculates the arctangent of all elements.",
+	"atanh":              "Calculates the inverse hyperbolic tangent of all elements.",
+	"avg_over_time":      "The average value of all points in the specified interval.",
+	"ceil":               "Rounds the sample values of all elements up to the nearest integer.",
+	"changes":            "Returns the number of times the value of each series has changed within the provided time range.",
+	"clamp":              "Clamps the sample values of all elements to have a lower limit of min and an upper limit of max.",
+	"clamp_max":          "Clamps the sample values of all elements to have an upper limit of max.",
+	"clamp_min":          "Clamps the sample values of all elements to have a lower limit of min.",
+	"cos":                "Calculates the cosine of all elements.",
+	"cosh":               "Calculates the hyperbolic cosine of all elements.",
+	"count_over_time":    "The count of all values in the specified interval.",
+	"day_of_month":       "Returns the day of the month for each of the given times in UTC. Returned values are from 1 to 31.",
+	"day_of_week":        "Returns the day of the week for each of the given times in UTC. Returned values are from 0 to 6, where 0 means Sunday.",
+	"day_of_year":        "Returns the day of the year for each of the given times in UTC. Returned values are from 1 to 365 for non-leap years, and 1 to 366 in leap years.",
+	"days_in_month":      "Returns number of days in the month for each of the given times in UTC. Returned values are from 28 to 31.",
+	"deg":                "Converts radians to degrees for all elements.",
+	"delta":              "Calculates the difference between the first and last value of each time series element in a range vector. Should only be used with gauges.",
+	"deriv":              "Calculates the per-second derivative of the time series in a range vector using simple linear regression. Should only be used with gauges.",
+	"exp":                "Calculates the exponential function for all elements.",
+	"floor":              "Rounds the sample values of all elements down to the nearest integer.",
+	"histogram_quantile": "Calculates the φ-quantile (0 ≤ φ ≤ 1) from the buckets of a histogram. The buckets need to have a label \"le\", which has to be kept when aggregating, for example: histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))",
+	"holt_winters":       "Produces a smoothed value for time series based on the range. The smoothing factor and the trend factor need to be between 0 and 1. Should only be used with gauges.",
+	"hour":               "Returns the hour of the day for each of the given times in UTC. Returned values are from 0 to 23.",
+	"idelta":             "Calculates the difference between the last two samples in the range vector. Should only be used with gauges.",
+	"increase":           "Calculates the increase in the time series in the range vector. Breaks in monotonicity (such as counter resets) are automatically adjusted for. Should only be used with counters.",
+	"irate":              "Calculates the per-second instant rate of increase of the time series in the range vector, based on the last two data points. Should only be used with counters.",
+	"label_join":         "Joins the values of all the source labels using the separator and stores the result in the destination label: label_join(v, dst_label, separator, src_label_1, ...)",
+	"label_replace":      "Matches the regular expression against the value of the source label. If it matches, the destination label is set to the replacement: label_replace(v, dst_label, replacement, src_label, regex)",
+	"last_over_time":     "The most recent point value in the specified interval.",
+	"ln":                 "Calculates the natural logarithm for all elements.",
+	"log10":              "Calculates the decimal logarithm for all elements.",
+	"log2":               "Calculates the binary logarithm for all elements.",
+	"max_over_time":      "The maximum value of all points in the specified interval.",
+	"min_over_time":      "The minimum value of all points in the specified interval.",
+	"minute":             "Returns the minute of the hour for each of the given times in UTC. Returned values are from 0 to 59.",
+	"month":              "Returns the month of the year for each of the given times in UTC. Returned values are from 1 to 12.",
+	"pi":                 "Returns pi.",
+	"predict_linear":     "Predicts the value of time series t seconds from now, based on the range vector, using simple linear regression. Should only be used with gauges.",
+	"present_over_time":  "Returns the value 1 for any series in the specified interval.",
+	"quantile_over_time": "The φ-quantile (0 ≤ φ ≤ 1) of the values in the specified interval.",
+	"rad":                "Converts degrees to radians for all elements.",
+	"rate":               "Calculates the per-second average rate of increase of the time series in the range vector. Breaks in monotonicity (such as counter resets) are automatically adjusted for. Should only be used with counters. The range should be at least four times the scrape interval.",
+	"resets":             "Returns the number of counter resets within the provided time range. Should only be used with counters.",
+	"round":              "Rounds the sample values of all elements to the nearest integer, or to the nearest multiple of the optional second argument.",
+	"scalar":             "Returns the sample value of a single-element vector as a scalar. If the vector does not have exactly one element, NaN is returned.",
+	"sgn":                "Returns a vector with all sample values converted to their sign: 1 for positive, -1 for negative and 0 for zero values.",
+	"sin":                "Calculates the sine of all elements.",
+	"sinh":               "Calculates the hyperbolic sine of all elements.",
+	"sort":               "Returns vector elements sorted by their sample values, in ascending order.",
+	"sort_desc":          "Returns vector elements sorted by their sample values, in descending order.",
+	"sqrt":               "Calculates the square root of all elements.",
+	"stddev_over_time":   "The population standard deviation of the values in the specified interval.",
+	"stdvar_over_time":   "The population standard variance of the values in the specified interval.",
+	"sum_over_time":      "The sum of all values in the specified interval.",
+	"tan":                "Calculates the tangent of all elements.",
+	"tanh":               "Calculates the hyperbolic tangent of all elements.",
+	"time":               "Returns the number of seconds since January 1, 1970 UTC. This is the evaluation time of the expression, not the current time.",
+	"timestamp":          "Returns the timestamp of each of the samples of the given vector as the number of seconds since January 1, 1970 UTC.",
+	"vector":             "Returns the scalar as a vector with no labels.",
+	"year":               "Returns the year for each of the given times in UTC.",
+}
+
+// AggregationDocs contains a short description of every aggregation operator.
+var AggregationDocs = map[string]string{
+	"avg":          "Calculates the average over dimensions.",
+	"bottomk":      "Returns the smallest k elements by sample value.",
+	"count":        "Counts the number of elements in the vector.",
+	"count_values": "Counts the number of elements with the same value. The value is stored in the label given by the parameter.",
+	"group":        "All values in the resulting vector are 1.",
+	"max":          "Selects the maximum over dimensions.",
+	"min":          "Selects the minimum over dimensions.",
+	"quantile":     "Calculates the φ-quantile (0 ≤ φ ≤ 1) over dimensions.",
+	"stddev":       "Calculates the population standard deviation over dimensions.",
+	"stdvar":       "Calculates the population standard variance over dimensions.",
+	"sum":          "Calculates the sum over dimensions.",
+	"topk":         "Returns the largest k elements by sample value.",
+}
@@ -0,0 +1,48 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseError is a syntax or type error in a PromQL expression.
+type ParseError struct {
+	Pos PositionRange
+	// Line and Column of the start of the error, both starting at one.
+	// The column is counted in characters.
+	Line    int
+	Column  int
+	Message string
+	// Hint is a suggestion for fixing common mistakes. It can be empty.
+	Hint string
+	// Unknown is set when the input calls a function the parser does not know.
+	// Newer servers may still support it.
+	Unknown bool
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+func newError(input string, start, end int, format string, args ...interface{}) *ParseError {
+	line, column := LineColumn(input, start)
+	return &ParseError{
+		Pos:     PositionRange{start, end},
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// LineColumn converts a byte offset in the input to a line and column, both starting at one.
+func LineColumn(input string, pos int) (line, column int) {
+	if pos > len(input) {
+		pos = len(input)
+	}
+
+	before := input[:pos]
+	line = strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCountInString(before[lineStart:]) + 1
+}
@@ -0,0 +1,203 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxLineWidth is the width up to which expressions are kept on a single line when formatting.
+	maxLineWidth = 80
+	indentUnit   = "  "
+)
+
+// String returns the expression on a single line with normalised durations, strings and matchers.
+func String(expr Expr) string {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		switch {
+		case math.IsInf(e.Val, 1):
+			return "Inf"
+		case math.IsNaN(e.Val):
+			return "NaN"
+		}
+		return e.Text
+	case *StringLiteral:
+		return strconv.Quote(e.Val)
+	case *VectorSelector:
+		return selectorString(e) + modifierString(e.Offset, e.At)
+	case *MatrixSelector:
+		return fmt.Sprintf("%s[%s]%s", selectorString(e.Vector), FormatDuration(e.Range), modifierString(e.Offset, e.At))
+	case *SubqueryExpr:
+		return String(e.Expr) + subqueryString(e)
+	case *Call:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = String(arg)
+		}
+		return fmt.Sprintf("%s(%s)", e.Func.Name, strings.Join(args, ", "))
+	case *AggregateExpr:
+		args := String(e.Expr)
+		if e.Param != nil {
+			args = String(e.Param) + ", " + args
+		}
+		return fmt.Sprintf("%s(%s)", AggregationHead(e), args)
+	case *BinaryExpr:
+		return fmt.Sprintf("%s %s %s", String(e.LHS), OperatorString(e), String(e.RHS))
+	case *UnaryExpr:
+		return e.Op + String(e.Expr)
+	case *ParenExpr:
+		return "(" + String(e.Expr) + ")"
+	}
+	return ""
+}
+
+// Format returns the expression indented over multiple lines.
+// Sub-expressions which fit in a line are kept on a single line.
+func Format(expr Expr) string {
+	return format(expr, 0)
+}
+
+// format formats the expression with every line indented by the given level.
+func format(expr Expr, level int) string {
+	indent := strings.Repeat(indentUnit, level)
+	line := String(expr)
+	if utf8.RuneCountInString(indent+line) <= maxLineWidth {
+		return indent + line
+	}
+
+	switch e := expr.(type) {
+	case *SubqueryExpr:
+		return format(e.Expr, level) + subqueryString(e)
+	case *Call:
+		if len(e.Args) == 0 {
+			break
+		}
+
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = format(arg, level+1)
+		}
+		return fmt.Sprintf("%s%s(\n%s\n%s)", indent, e.Func.Name, strings.Join(args, ",\n"), indent)
+	case *AggregateExpr:
+		args := format(e.Expr, level+1)
+		if e.Param != nil {
+			args = format(e.Param, level+1) + ",\n" + args
+		}
+		return fmt.Sprintf("%s%s(\n%s\n%s)", indent, AggregationHead(e), args, indent)
+	case *BinaryExpr:
+		return fmt.Sprintf("%s\n%s%s\n%s", format(e.LHS, level), indent, OperatorString(e), format(e.RHS, level))
+	case *UnaryExpr:
+		return indent + e.Op + strings.TrimLeft(format(e.Expr, level), " ")
+	case *ParenExpr:
+		return fmt.Sprintf("%s(\n%s\n%s)", indent, format(e.Expr, level+1), indent)
+	}
+
+	return indent + line
+}
+
+func selectorString(e *VectorSelector) string {
+	if len(e.Matchers) == 0 {
+		return e.Name
+	}
+
+	matchers := make([]string, len(e.Matchers))
+	for i, m := range e.Matchers {
+		matchers[i] = fmt.Sprintf("%s%s%s", m.Name, m.Type, strconv.Quote(m.Value))
+	}
+	return fmt.Sprintf("%s{%s}", e.Name, strings.Join(matchers, ", "))
+}
+
+func modifierString(offset time.Duration, at string) string {
+	result := ""
+	if at != "" {
+		result += " @ " + at
+	}
+	if offset < 0 {
+		result += " offset -" + FormatDuration(-offset)
+	} else if offset > 0 {
+		result += " offset " + FormatDuration(offset)
+	}
+	return result
+}
+
+func subqueryString(e *SubqueryExpr) string {
+	step := ""
+	if e.Step != 0 {
+		step = FormatDuration(e.Step)
+	}
+	return fmt.Sprintf("[%s:%s]%s", FormatDuration(e.Range), step, modifierString(e.Offset, e.At))
+}
+
+// AggregationHead returns the operator of an aggregation together with its grouping, for example "sum by (job) ".
+func AggregationHead(e *AggregateExpr) string {
+	if e.Grouping == nil {
+		return e.Op
+	}
+
+	keyword := "by"
+	if e.Without {
+		keyword = "without"
+	}
+	return fmt.Sprintf("%s %s (%s) ", e.Op, keyword, strings.Join(e.Grouping, ", "))
+}
+
+// OperatorString returns the operator of a binary expression together with its modifiers, for example "/ on (job) group_left".
+func OperatorString(e *BinaryExpr) string {
+	result := e.Op
+	if e.ReturnBool {
+		result += " bool"
+	}
+
+	if m := e.Matching; m != nil {
+		keyword := "ignoring"
+		if m.On {
+			keyword = "on"
+		}
+		result += fmt.Sprintf(" %s (%s)", keyword, strings.Join(m.Labels, ", "))
+
+		switch m.Card {
+		case CardManyToOne:
+			result += " group_left"
+		case CardOneToMany:
+			result += " group_right"
+		}
+		if len(m.Include) > 0 {
+			result += fmt.Sprintf(" (%s)", strings.Join(m.Include, ", "))
+		}
+	}
+	return result
+}
+
+var formatUnits = []struct {
+	unit     time.Duration
+	duration string
+}{
+	{365 * 24 * time.Hour, "y"},
+	{7 * 24 * time.Hour, "w"},
+	{24 * time.Hour, "d"},
+	{time.Hour, "h"},
+	{time.Minute, "m"},
+	{time.Second, "s"},
+	{time.Millisecond, "ms"},
+}
+
+// FormatDuration formats a duration the way it is written in PromQL, for example 1h30m.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+
+	result := ""
+	for _, u := range formatUnits {
+		if n := d / u.unit; n > 0 {
+			result += fmt.Sprintf("%d%s", n, u.duration)
+			d -= n * u.unit
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package promql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		out   string
+	}{
+		{
+			desc:  "short",
+			input: "sum   by(job)(rate( x [ 90m ] ))",
+			out:   "sum by (job) (rate(x[1h30m]))",
+		},
+		{
+			desc:  "normalised matchers",
+			input: `up{job='a',instance!~` + "`b.*`" + `}`,
+			out:   `up{job="a", instance!~"b.*"}`,
+		},
+		{
+			desc:  "grouping after aggregation",
+			input: "sum(x) without(instance)",
+			out:   "sum without (instance) (x)",
+		},
+		{
+			desc:  "modifiers",
+			input: "x offset 1d + x @ end() + max_over_time(y[5m:] offset -1h)",
+			out:   "x offset 1d + x @ end() + max_over_time(y[5m:] offset -1h)",
+		},
+		{
+			desc:  "vector matching",
+			input: "a/on(job)group_left(team)b > bool 1",
+			out:   "a / on (job) group_left (team) b > bool 1",
+		},
+		{
+			desc:  "long binary expression",
+			input: `sum by (job) (rate(http_requests_total{status=~"5.."}[5m])) / sum by (job) (rate(http_requests_total[5m]))`,
+			out: `sum by (job) (rate(http_requests_total{status=~"5.."}[5m]))
+/
+sum by (job) (rate(http_requests_total[5m]))`,
+		},
+		{
+			desc:  "nested aggregations",
+			input: `histogram_quantile(0.99, sum by (le, job) (rate(http_request_duration_seconds_bucket{job="api-server"}[5m])))`,
+			out: `histogram_quantile(
+  0.99,
+  sum by (le, job) (
+    rate(http_request_duration_seconds_bucket{job="api-server"}[5m])
+  )
+)`,
+		},
+		{
+			desc:  "long parentheses",
+			input: `topk(5, (sum by (instance) (rate(node_cpu_seconds_total{mode!="idle", instance=~"web-.*"}[5m])) * 100))`,
+			out: `topk(
+  5,
+  (
+    sum by (instance) (
+      rate(node_cpu_seconds_total{mode!="idle", instance=~"web-.*"}[5m])
+    )
+    *
+    100
+  )
+)`,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			expr, err := ParseExpr(test.input)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			out := Format(expr)
+			if out != test.out {
+				t.Errorf("got\n%s\nwanted\n%s", out, test.out)
+			}
+
+			if _, err := ParseExpr(out); err != nil {
+				t.Errorf("formatted expression is not valid: %s", err)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for _, test := range []struct {
+		duration time.Duration
+		out      string
+	}{
+		{0, "0s"},
+		{90 * time.Second, "1m30s"},
+		{36 * time.Hour, "1d12h"},
+		{1500 * time.Millisecond, "1s500ms"},
+	} {
+		if out := FormatDuration(test.duration); out != test.out {
+			t.Errorf("%s: got %q, wanted %q", test.duration, out, test.out)
+		}
+	}
+}
+
+func TestHasComment(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		out   bool
+	}{
+		{"sum(up)", false},
+		{"# requests\nsum(up)", true},
+		{"sum(up) # requests", true},
+		{`up{job="#1"}`, false},
+		{"up{job=`a\"#`}", false},
+		{`up{job="a\"#"}`, false},
+		{`up{job="#`, false},
+	} {
+		if out := HasComment(test.input); out != test.out {
+			t.Errorf("%q: got %v, wanted %v", test.input, out, test.out)
+		}
+	}
+}
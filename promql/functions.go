@@ -0,0 +1,201 @@
+// Package promql contains definitions and tools for working with the Prometheus query language.
+package promql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValueType describes the type of a PromQL expression.
+type ValueType string
+
+// Types of PromQL expressions.
+const (
+	ValueTypeNone   ValueType = "none"
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "instant vector"
+	ValueTypeMatrix ValueType = "range vector"
+	ValueTypeString ValueType = "string"
+)
+
+// Function describes a PromQL function.
+type Function struct {
+	Name     string
+	ArgTypes []ValueType
+	// Optional is the number of arguments at the end of ArgTypes which can be omitted.
+	Optional int
+	// Variadic is true if the last argument can be repeated.
+	Variadic   bool
+	ReturnType ValueType
+}
+
+func vectorFunc(name string, argTypes ...ValueType) *Function {
+	return &Function{Name: name, ArgTypes: argTypes, ReturnType: ValueTypeVector}
+}
+
+// optionalArgs marks the last n arguments as optional.
+func optionalArgs(f *Function, n int) *Function {
+	f.Optional = n
+	return f
+}
+
+// variadic marks the last argument as repeatable.
+func variadic(f *Function) *Function {
+	f.Variadic = true
+	return f
+}
+
+// Functions contains all functions known by PromQL.
+var Functions = map[string]*Function{}
+
+func init() {
+	for _, f := range []*Function{
+		vectorFunc("abs", ValueTypeVector),
+		vectorFunc("absent", ValueTypeVector),
+		vectorFunc("absent_over_time", ValueTypeMatrix),
+		vectorFunc("acos", ValueTypeVector),
+		vectorFunc("acosh", ValueTypeVector),
+		vectorFunc("asin", ValueTypeVector),
+		vectorFunc("asinh", ValueTypeVector),
+		vectorFunc("atan", ValueTypeVector),
+		vectorFunc("atanh", ValueTypeVector),
+		vectorFunc("avg_over_time", ValueTypeMatrix),
+		vectorFunc("ceil", ValueTypeVector),
+		vectorFunc("changes", ValueTypeMatrix),
+		vectorFunc("clamp", ValueTypeVector, ValueTypeScalar, ValueTypeScalar),
+		vectorFunc("clamp_max", ValueTypeVector, ValueTypeScalar),
+		vectorFunc("clamp_min", ValueTypeVector, ValueTypeScalar),
+		vectorFunc("cos", ValueTypeVector),
+		vectorFunc("cosh", ValueTypeVector),
+		vectorFunc("count_over_time", ValueTypeMatrix),
+		optionalArgs(vectorFunc("day_of_month", ValueTypeVector), 1),
+		optionalArgs(vectorFunc("day_of_week", ValueTypeVector), 1),
+		optionalArgs(vectorFunc("day_of_year", ValueTypeVector), 1),
+		optionalArgs(vectorFunc("days_in_month", ValueTypeVector), 1),
+		vectorFunc("deg", ValueTypeVector),
+		vectorFunc("delta", ValueTypeMatrix),
+		vectorFunc("deriv", ValueTypeMatrix),
+		vectorFunc("exp", ValueTypeVector),
+		vectorFunc("floor", ValueTypeVector),
+		vectorFunc("histogram_quantile", ValueTypeScalar, ValueTypeVector),
+		vectorFunc("holt_winters", ValueTypeMatrix, ValueTypeScalar, ValueTypeScalar),
+		optionalArgs(vectorFunc("hour", ValueTypeVector), 1),
+		vectorFunc("idelta", ValueTypeMatrix),
+		vectorFunc("increase", ValueTypeMatrix),
+		vectorFunc("irate", ValueTypeMatrix),
+		variadic(vectorFunc("label_join", ValueTypeVector, ValueTypeString, ValueTypeString, ValueTypeString)),
+		vectorFunc("label_replace", ValueTypeVector, ValueTypeString, ValueTypeString, ValueTypeString, ValueTypeString),
+		vectorFunc("last_over_time", ValueTypeMatrix),
+		vectorFunc("ln", ValueTypeVector),
+		vectorFunc("log10", ValueTypeVector),
+		vectorFunc("log2", ValueTypeVector),
+		vectorFunc("max_over_time", ValueTypeMatrix),
+		vectorFunc("min_over_time", ValueTypeMatrix),
+		optionalArgs(vectorFunc("minute", ValueTypeVector), 1),
+		optionalArgs(vectorFunc("month", ValueTypeVector), 1),
+		{Name: "pi", ReturnType: ValueTypeScalar},
+		vectorFunc("predict_linear", ValueTypeMatrix, ValueTypeScalar),
+		vectorFunc("present_over_time", ValueTypeMatrix),
+		vectorFunc("quantile_over_time", ValueTypeScalar, ValueTypeMatrix),
+		vectorFunc("rad", ValueTypeVector),
+		vectorFunc("rate", ValueTypeMatrix),
+		vectorFunc("resets", ValueTypeMatrix),
+		optionalArgs(vectorFunc("round", ValueTypeVector, ValueTypeScalar), 1),
+		{Name: "scalar", ArgTypes: []ValueType{ValueTypeVector}, ReturnType: ValueTypeScalar},
+		vectorFunc("sgn", ValueTypeVector),
+		vectorFunc("sin", ValueTypeVector),
+		vectorFunc("sinh", ValueTypeVector),
+		vectorFunc("sort", ValueTypeVector),
+		vectorFunc("sort_desc", ValueTypeVector),
+		vectorFunc("sqrt", ValueTypeVector),
+		vectorFunc("stddev_over_time", ValueTypeMatrix),
+		vectorFunc("stdvar_over_time", ValueTypeMatrix),
+		vectorFunc("sum_over_time", ValueTypeMatrix),
+		vectorFunc("tan", ValueTypeVector),
+		vectorFunc("tanh", ValueTypeVector),
+		{Name: "time", ReturnType: ValueTypeScalar},
+		vectorFunc("timestamp", ValueTypeVector),
+		vectorFunc("vector", ValueTypeScalar),
+		optionalArgs(vectorFunc("year", ValueTypeVector), 1),
+	} {
+		Functions[f.Name] = f
+	}
+}
+
+// AggregationParams contains the type of the parameter of aggregations, which take one.
+var AggregationParams = map[string]ValueType{
+	"bottomk":      ValueTypeScalar,
+	"count_values": ValueTypeString,
+	"quantile":     ValueTypeScalar,
+	"topk":         ValueTypeScalar,
+}
+
+// Aggregations contains the aggregation operators of PromQL.
+var Aggregations = []string{
+	"avg",
+	"bottomk",
+	"count",
+	"count_values",
+	"group",
+	"max",
+	"min",
+	"quantile",
+	"stddev",
+	"stdvar",
+	"sum",
+	"topk",
+}
+
+// Keywords contains the keywords of PromQL, which are not functions or aggregations.
+var Keywords = []string{
+	"and",
+	"bool",
+	"by",
+	"group_left",
+	"group_right",
+	"ignoring",
+	"offset",
+	"on",
+	"or",
+	"unless",
+	"without",
+}
+
+// FunctionNames returns the names of all functions, sorted alphabetically.
+func FunctionNames() []string {
+	names := make([]string, 0, len(Functions))
+	for name := range Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Signature returns the signature of the function in the style of the Prometheus documentation.
+func (f *Function) Signature() string {
+	args := make([]string, len(f.ArgTypes))
+	for i, t := range f.ArgTypes {
+		args[i] = typeName(t)
+		if i >= len(f.ArgTypes)-f.Optional {
+			args[i] += "?"
+		}
+	}
+	if f.Variadic {
+		args = append(args, "...")
+	}
+	return fmt.Sprintf("%s(%s) %s", f.Name, strings.Join(args, ", "), typeName(f.ReturnType))
+}
+
+// AggregationSignature returns the signature of an aggregation operator.
+func AggregationSignature(name string) string {
+	args := []string{typeName(ValueTypeVector)}
+	if param, ok := AggregationParams[name]; ok {
+		args = append([]string{typeName(param)}, args...)
+	}
+	return fmt.Sprintf("%s [by|without (<label list>)] (%s) %s", name, strings.Join(args, ", "), typeName(ValueTypeVector))
+}
+
+func typeName(t ValueType) string {
+	return strings.Replace(string(t), " ", "-", -1)
+}
@@ -0,0 +1,271 @@
+package promql
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// itemType is the type of a token.
+type itemType int
+
+const (
+	itemEOF itemType = iota
+	itemIdentifier
+	itemNumber
+	itemDuration
+	itemString
+
+	itemLeftParen
+	itemRightParen
+	itemLeftBrace
+	itemRightBrace
+	itemLeftBracket
+	itemRightBracket
+	itemComma
+	itemColon
+	itemAssign
+	itemAt
+
+	// Operators
+	itemADD
+	itemSUB
+	itemMUL
+	itemDIV
+	itemMOD
+	itemPOW
+	itemEQL
+	itemNEQ
+	itemLSS
+	itemGTR
+	itemLTE
+	itemGTE
+	itemEQLRegex
+	itemNEQRegex
+	itemLAND
+	itemLOR
+	itemLUnless
+	itemATAN2
+
+	// Keywords
+	itemBy
+	itemWithout
+	itemOn
+	itemIgnoring
+	itemGroupLeft
+	itemGroupRight
+	itemBool
+	itemOffset
+)
+
+// item is a token of a PromQL expression.
+type item struct {
+	typ itemType
+	// val is the text of the token as it appears in the input.
+	val string
+	// pos is the byte offset of the token in the input.
+	pos int
+}
+
+func (i item) end() int {
+	return i.pos + len(i.val)
+}
+
+// describe returns a description of the token for error messages.
+func (i item) describe() string {
+	if i.typ == itemEOF {
+		return "end of input"
+	}
+	return "\"" + i.val + "\""
+}
+
+var keywords = map[string]itemType{
+	"and":         itemLAND,
+	"or":          itemLOR,
+	"unless":      itemLUnless,
+	"atan2":       itemATAN2,
+	"by":          itemBy,
+	"without":     itemWithout,
+	"on":          itemOn,
+	"ignoring":    itemIgnoring,
+	"group_left":  itemGroupLeft,
+	"group_right": itemGroupRight,
+	"bool":        itemBool,
+	"offset":      itemOffset,
+}
+
+type operator struct {
+	text string
+	typ  itemType
+}
+
+var operators = []operator{
+	// Two character operators need to be checked first
+	{"==", itemEQL},
+	{"!=", itemNEQ},
+	{"<=", itemLTE},
+	{">=", itemGTE},
+	{"=~", itemEQLRegex},
+	{"!~", itemNEQRegex},
+	{"+", itemADD},
+	{"-", itemSUB},
+	{"*", itemMUL},
+	{"/", itemDIV},
+	{"%", itemMOD},
+	{"^", itemPOW},
+	{"<", itemLSS},
+	{">", itemGTR},
+	{"=", itemAssign},
+	{"(", itemLeftParen},
+	{")", itemRightParen},
+	{"{", itemLeftBrace},
+	{"}", itemRightBrace},
+	{"[", itemLeftBracket},
+	{"]", itemRightBracket},
+	{",", itemComma},
+	{":", itemColon},
+	{"@", itemAt},
+}
+
+var (
+	durationRegex = regexp.MustCompile(`^([0-9]+(ms|[smhdwy]))+`)
+	numberRegex   = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|([0-9]*\.?[0-9]+|[0-9]+\.)([eE][+-]?[0-9]+)?)`)
+)
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == ':' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+func isIdentifierRune(r rune) bool {
+	return isIdentifierStart(r) || ('0' <= r && r <= '9')
+}
+
+// lex splits the input into tokens. Whitespace and comments are skipped.
+func lex(input string) ([]item, error) {
+	var (
+		items []item
+		// Inside of ranges colons separate the range from the step of subqueries
+		inBrackets bool
+	)
+
+	pos := 0
+	for pos < len(input) {
+		r, size := utf8.DecodeRuneInString(input[pos:])
+		rest := input[pos:]
+
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+			continue
+		case r == '#':
+			if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				pos += end
+			} else {
+				pos = len(input)
+			}
+			continue
+		case r == '"' || r == '\'' || r == '`':
+			end, err := scanString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item{itemString, input[pos:end], pos})
+			pos = end
+			continue
+		case '0' <= r && r <= '9' || r == '.':
+			typ, match := itemDuration, durationRegex.FindString(rest)
+			if match == "" {
+				typ, match = itemNumber, numberRegex.FindString(rest)
+			}
+			if match == "" {
+				return nil, newError(input, pos, pos+size, "unexpected character %q", r)
+			}
+
+			end := pos + len(match)
+			if next, _ := utf8.DecodeRuneInString(input[end:]); end < len(input) && next != ':' && (isIdentifierRune(next) || next == '.') {
+				for end < len(input) && input[end] != ':' && (isIdentifierRune(rune(input[end])) || input[end] == '.') {
+					end++
+				}
+				return nil, newError(input, pos, end, "bad number or duration syntax: %q", input[pos:end])
+			}
+
+			items = append(items, item{typ, match, pos})
+			pos = end
+			continue
+		case isIdentifierStart(r) && !(inBrackets && r == ':'):
+			end := pos + size
+			for end < len(input) && isIdentifierRune(rune(input[end])) && !(inBrackets && input[end] == ':') {
+				end++
+			}
+
+			word := input[pos:end]
+			typ, ok := keywords[strings.ToLower(word)]
+			if !ok {
+				typ = itemIdentifier
+			}
+			items = append(items, item{typ, word, pos})
+			pos = end
+			continue
+		}
+
+		op, ok := lexOperator(rest)
+		if !ok {
+			return nil, newError(input, pos, pos+size, "unexpected character %q", r)
+		}
+
+		switch op.typ {
+		case itemLeftBracket:
+			inBrackets = true
+		case itemRightBracket:
+			inBrackets = false
+		}
+		items = append(items, item{op.typ, op.text, pos})
+		pos += len(op.text)
+	}
+
+	return append(items, item{itemEOF, "", len(input)}), nil
+}
+
+// lexOperator returns the operator at the start of the input.
+func lexOperator(input string) (operator, bool) {
+	for _, op := range operators {
+		if strings.HasPrefix(input, op.text) {
+			return op, true
+		}
+	}
+	return operator{}, false
+}
+
+// HasComment reports whether the input contains a comment outside of strings.
+func HasComment(input string) bool {
+	for pos := 0; pos < len(input); pos++ {
+		switch input[pos] {
+		case '#':
+			return true
+		case '"', '\'', '`':
+			end, err := scanString(input, pos)
+			if err != nil {
+				return false
+			}
+			pos = end - 1
+		}
+	}
+	return false
+}
+
+// scanString returns the end of the string starting at pos.
+func scanString(input string, pos int) (int, error) {
+	quote := input[pos]
+	for i := pos + 1; i < len(input); i++ {
+		switch {
+		case input[i] == '\\' && quote != '`':
+			i++
+		case input[i] == '\n' && quote != '`':
+			return 0, newError(input, pos, i, "unterminated quoted string")
+		case input[i] == quote:
+			return i + 1, nil
+		}
+	}
+	return 0, newError(input, pos, len(input), "unterminated quoted string")
+}
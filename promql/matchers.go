@@ -0,0 +1,49 @@
+package promql
+
+import "fmt"
+
+// ParseMatchers parses a list of label matchers like {cluster="eu-1", env="prod"}.
+func ParseMatchers(input string) ([]*LabelMatcher, error) {
+	expr, err := ParseExpr(input)
+	if err != nil {
+		return nil, err
+	}
+
+	sel, ok := expr.(*VectorSelector)
+	if !ok || sel.Name != "" || sel.Offset != 0 || sel.At != "" {
+		return nil, fmt.Errorf("not a list of label matchers: %s", input)
+	}
+
+	return sel.Matchers, nil
+}
+
+// MatchersString formats label matchers as a selector without metric name.
+func MatchersString(matchers []*LabelMatcher) string {
+	if len(matchers) == 0 {
+		return "{}"
+	}
+	return selectorString(&VectorSelector{Matchers: matchers})
+}
+
+// InjectMatchers adds the matchers to all vector selectors of the expression.
+// Selectors which already contain a matcher for a label keep their own matcher.
+func InjectMatchers(expr Expr, matchers []*LabelMatcher) {
+	Walk(expr, func(e Expr) {
+		sel, ok := e.(*VectorSelector)
+		if !ok {
+			return
+		}
+
+		existing := map[string]bool{}
+		for _, m := range sel.Matchers {
+			existing[m.Name] = true
+		}
+
+		for _, m := range matchers {
+			if !existing[m.Name] {
+				injected := *m
+				sel.Matchers = append(sel.Matchers, &injected)
+			}
+		}
+	})
+}
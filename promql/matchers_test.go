@@ -0,0 +1,60 @@
+package promql
+
+import "testing"
+
+func TestInjectMatchers(t *testing.T) {
+	matchers, err := ParseMatchers(`{cluster="eu-1", env=~"prod|staging"}`)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	for _, test := range []struct {
+		desc  string
+		input string
+		out   string
+	}{
+		{
+			desc:  "selector",
+			input: "up",
+			out:   `up{cluster="eu-1", env=~"prod|staging"}`,
+		},
+		{
+			desc:  "all selectors",
+			input: `sum(rate(a{job="x"}[5m])) / on(job) b offset 1h`,
+			out:   `sum(rate(a{job="x", cluster="eu-1", env=~"prod|staging"}[5m])) / on (job) b{cluster="eu-1", env=~"prod|staging"} offset 1h`,
+		},
+		{
+			desc:  "existing matcher is kept",
+			input: `up{cluster="us-1"}`,
+			out:   `up{cluster="us-1", env=~"prod|staging"}`,
+		},
+		{
+			desc:  "subquery",
+			input: "max_over_time(rate(x[5m])[1h:])",
+			out:   `max_over_time(rate(x{cluster="eu-1", env=~"prod|staging"}[5m])[1h:])`,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			expr, err := ParseExpr(test.input)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			InjectMatchers(expr, matchers)
+			if out := String(expr); out != test.out {
+				t.Errorf("got %s, wanted %s", out, test.out)
+			}
+		})
+	}
+}
+
+func TestParseMatchersInvalid(t *testing.T) {
+	for _, input := range []string{"up", `{job="a"}[5m]`, `{job=}`} {
+		if _, err := ParseMatchers(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,769 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parser builds the syntax tree from the tokens of an expression.
+// Errors are raised using panic and recovered in ParseExpr.
+type parser struct {
+	input string
+	items []item
+	pos   int
+	// lastEnd is the end of the last consumed token.
+	lastEnd int
+}
+
+// ParseExpr parses a PromQL expression and checks the types of all sub-expressions.
+// The returned error is a *ParseError.
+func ParseExpr(input string) (expr Expr, err error) {
+	items, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		input: input,
+		items: items,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			parseErr, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			expr, err = nil, parseErr
+		}
+	}()
+
+	if p.peek().typ == itemEOF {
+		p.errorf(p.peek(), "no expression found in input")
+	}
+
+	expr = p.expr(0)
+	if t := p.peek(); t.typ != itemEOF {
+		p.unexpected(t, "end of input")
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() item {
+	return p.items[p.pos]
+}
+
+func (p *parser) next() item {
+	t := p.items[p.pos]
+	if t.typ != itemEOF {
+		p.pos++
+	}
+	p.lastEnd = t.end()
+	return t
+}
+
+func (p *parser) expect(typ itemType, expected string) item {
+	t := p.next()
+	if t.typ != typ {
+		p.unexpected(t, expected)
+	}
+	return t
+}
+
+func (p *parser) errorf(t item, format string, args ...interface{}) {
+	panic(newError(p.input, t.pos, t.end(), format, args...))
+}
+
+// errorHint raises an error spanning the given range with a hint.
+func (p *parser) errorHint(pos PositionRange, hint string, format string, args ...interface{}) {
+	err := newError(p.input, pos.Start, pos.End, format, args...)
+	err.Hint = hint
+	panic(err)
+}
+
+func (p *parser) unexpected(t item, expected string) {
+	p.errorf(t, "unexpected %s, expected %s", t.describe(), expected)
+}
+
+// text returns the input covered by an expression.
+func (p *parser) text(e Expr) string {
+	pos := e.Position()
+	return p.input[pos.Start:pos.End]
+}
+
+// precedence returns the precedence of a binary operator, higher binds stronger.
+// Zero is returned for tokens which are not binary operators.
+func precedence(typ itemType) int {
+	switch typ {
+	case itemLOR:
+		return 1
+	case itemLAND, itemLUnless:
+		return 2
+	case itemEQL, itemNEQ, itemLTE, itemLSS, itemGTE, itemGTR:
+		return 3
+	case itemADD, itemSUB:
+		return 4
+	case itemMUL, itemDIV, itemMOD, itemATAN2:
+		return 5
+	case itemPOW:
+		return 6
+	default:
+		return 0
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<=", "<", ">=", ">":
+		return true
+	}
+	return false
+}
+
+func isSetOperator(op string) bool {
+	switch op {
+	case "and", "or", "unless":
+		return true
+	}
+	return false
+}
+
+// expr parses a binary expression containing only operators with at least the given precedence.
+func (p *parser) expr(minPrecedence int) Expr {
+	lhs := p.unary()
+	for {
+		op := p.peek()
+		prec := precedence(op.typ)
+		if prec == 0 {
+			if op.typ == itemBy || op.typ == itemWithout {
+				p.errorHint(PositionRange{op.pos, op.end()},
+					fmt.Sprintf("%s can only be used with aggregations, for example sum %s (job) (%s)", op.val, op.val, p.text(lhs)),
+					"unexpected %s after %s", op.describe(), p.describeExpr(lhs))
+			}
+			return lhs
+		}
+		if prec < minPrecedence {
+			return lhs
+		}
+		p.next()
+
+		expr := &BinaryExpr{
+			Op:  strings.ToLower(op.val),
+			LHS: lhs,
+		}
+		p.binaryModifiers(expr)
+
+		// The power operator is right-associative
+		next := prec + 1
+		if op.typ == itemPOW {
+			next = prec
+		}
+		expr.RHS = p.expr(next)
+		expr.Pos = PositionRange{lhs.Position().Start, expr.RHS.Position().End}
+
+		p.checkBinary(expr, op)
+		lhs = expr
+	}
+}
+
+func (p *parser) describeExpr(e Expr) string {
+	if call, ok := e.(*Call); ok {
+		return fmt.Sprintf("call of function %q", call.Func.Name)
+	}
+	return string(e.Type())
+}
+
+func (p *parser) binaryModifiers(expr *BinaryExpr) {
+	if p.peek().typ == itemBool {
+		t := p.next()
+		if !isComparison(expr.Op) {
+			p.errorf(t, "bool modifier can only be used on comparison operators")
+		}
+		expr.ReturnBool = true
+	}
+
+	switch p.peek().typ {
+	case itemOn, itemIgnoring:
+		t := p.next()
+		expr.Matching = &VectorMatching{
+			Card:   CardOneToOne,
+			On:     t.typ == itemOn,
+			Labels: p.labelList(),
+		}
+	default:
+		return
+	}
+
+	switch p.peek().typ {
+	case itemGroupLeft, itemGroupRight:
+		t := p.next()
+		if isSetOperator(expr.Op) {
+			p.errorf(t, "no grouping allowed for %q operation", expr.Op)
+		}
+
+		expr.Matching.Card = CardManyToOne
+		if t.typ == itemGroupRight {
+			expr.Matching.Card = CardOneToMany
+		}
+		if p.peek().typ == itemLeftParen {
+			expr.Matching.Include = p.labelList()
+		}
+	}
+}
+
+func (p *parser) checkBinary(expr *BinaryExpr, op item) {
+	lhs, rhs := expr.LHS.Type(), expr.RHS.Type()
+	for _, operand := range []Expr{expr.LHS, expr.RHS} {
+		if t := operand.Type(); t != ValueTypeScalar && t != ValueTypeVector {
+			hint := ""
+			if t == ValueTypeMatrix {
+				hint = fmt.Sprintf("use a function like rate(%s) to get an instant vector", p.text(operand))
+			}
+			p.errorHint(operand.Position(), hint, "binary expression must contain only scalar and instant vector types, got %s", t)
+		}
+	}
+
+	bothScalar := lhs == ValueTypeScalar && rhs == ValueTypeScalar
+	switch {
+	case isSetOperator(expr.Op) && (lhs == ValueTypeScalar || rhs == ValueTypeScalar):
+		p.errorf(op, "set operator %q not allowed in binary scalar expression", expr.Op)
+	case isComparison(expr.Op) && bothScalar && !expr.ReturnBool:
+		p.errorHint(PositionRange{op.pos, op.end()}, fmt.Sprintf("use %s bool", op.val), "comparisons between scalars must use bool modifier")
+	case expr.Matching != nil && (lhs == ValueTypeScalar || rhs == ValueTypeScalar):
+		p.errorf(op, "vector matching only allowed between instant vectors")
+	}
+}
+
+func (p *parser) unary() Expr {
+	t := p.peek()
+	if t.typ != itemADD && t.typ != itemSUB {
+		return p.postfix(p.primary())
+	}
+	p.next()
+
+	// Unary operators bind stronger than all binary operators except ^
+	expr := p.expr(precedence(itemPOW))
+	if typ := expr.Type(); typ != ValueTypeScalar && typ != ValueTypeVector {
+		p.errorf(t, "unary expression only allowed on expressions of type scalar or instant vector, got %s", typ)
+	}
+
+	return &UnaryExpr{
+		Op:   t.val,
+		Expr: expr,
+		Pos:  PositionRange{t.pos, expr.Position().End},
+	}
+}
+
+func (p *parser) primary() Expr {
+	t := p.peek()
+	switch t.typ {
+	case itemNumber:
+		p.next()
+		return p.number(t)
+	case itemString:
+		p.next()
+		return &StringLiteral{
+			Val: p.unquote(t),
+			Pos: PositionRange{t.pos, t.end()},
+		}
+	case itemLeftParen:
+		p.next()
+		expr := p.expr(0)
+		p.expect(itemRightParen, "\")\"")
+		return &ParenExpr{
+			Expr: expr,
+			Pos:  PositionRange{t.pos, p.lastEnd},
+		}
+	case itemLeftBrace:
+		return p.selector()
+	case itemIdentifier:
+		name := strings.ToLower(t.val)
+		switch {
+		case name == "inf" || name == "nan":
+			p.next()
+			return p.number(t)
+		case containsString(Aggregations, name) && p.isAggregation():
+			return p.aggregation()
+		case p.items[p.pos+1].typ == itemLeftParen:
+			return p.call()
+		}
+		return p.selector()
+	}
+
+	p.unexpected(t, "expression")
+	return nil
+}
+
+// isAggregation checks if the current identifier is followed by the start of an aggregation.
+func (p *parser) isAggregation() bool {
+	switch p.items[p.pos+1].typ {
+	case itemLeftParen, itemBy, itemWithout:
+		return true
+	}
+	return false
+}
+
+func (p *parser) number(t item) *NumberLiteral {
+	var (
+		v   float64
+		err error
+	)
+	switch strings.ToLower(t.val) {
+	case "inf":
+		v = math.Inf(1)
+	case "nan":
+		v = math.NaN()
+	default:
+		if strings.HasPrefix(strings.ToLower(t.val), "0x") {
+			var i int64
+			i, err = strconv.ParseInt(t.val[2:], 16, 64)
+			v = float64(i)
+		} else {
+			v, err = strconv.ParseFloat(t.val, 64)
+		}
+	}
+	if err != nil {
+		p.errorf(t, "not a valid number: %s", t.val)
+	}
+
+	return &NumberLiteral{
+		Val:  v,
+		Text: t.val,
+		Pos:  PositionRange{t.pos, t.end()},
+	}
+}
+
+func (p *parser) unquote(t item) string {
+	quote := t.val[0]
+	inner := t.val[1 : len(t.val)-1]
+	if quote == '`' {
+		return inner
+	}
+
+	result := &strings.Builder{}
+	for len(inner) > 0 {
+		r, _, tail, err := strconv.UnquoteChar(inner, quote)
+		if err != nil {
+			p.errorf(t, "not a valid string: %s", t.val)
+		}
+		result.WriteRune(r)
+		inner = tail
+	}
+	return result.String()
+}
+
+// labelName accepts identifiers and keywords, because keywords like "on" are valid label names.
+func (p *parser) labelName() item {
+	t := p.next()
+	if t.typ != itemIdentifier && t.typ < itemLAND || !labelNameRegex.MatchString(t.val) {
+		p.unexpected(t, "label name")
+	}
+	return t
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// labelList parses a list of label names in parentheses.
+func (p *parser) labelList() []string {
+	p.expect(itemLeftParen, "\"(\"")
+	labels := []string{}
+	for p.peek().typ != itemRightParen {
+		labels = append(labels, p.labelName().val)
+		if p.peek().typ != itemRightParen {
+			p.expect(itemComma, "\",\" or \")\"")
+		}
+	}
+	p.next()
+	return labels
+}
+
+func (p *parser) aggregation() Expr {
+	t := p.next()
+	expr := &AggregateExpr{
+		Op: strings.ToLower(t.val),
+	}
+
+	grouping := false
+	if typ := p.peek().typ; typ == itemBy || typ == itemWithout {
+		p.next()
+		expr.Without = typ == itemWithout
+		expr.Grouping = p.labelList()
+		grouping = true
+	}
+
+	p.expect(itemLeftParen, "\"(\"")
+	if paramType, ok := AggregationParams[expr.Op]; ok {
+		expr.Param = p.expr(0)
+		if typ := expr.Param.Type(); typ != paramType {
+			p.errorHint(expr.Param.Position(), fmt.Sprintf("%s needs a %s as first parameter, for example %s", expr.Op, paramType, aggregationExample(expr.Op)),
+				"expected type %s in aggregation parameter, got %s", paramType, typ)
+		}
+		p.expect(itemComma, "\",\"")
+	}
+
+	expr.Expr = p.expr(0)
+	if typ := expr.Expr.Type(); typ != ValueTypeVector {
+		hint := ""
+		if typ == ValueTypeMatrix {
+			hint = fmt.Sprintf("use a function like rate(%s) or avg_over_time(%s) to get an instant vector", p.text(expr.Expr), p.text(expr.Expr))
+		}
+		p.errorHint(expr.Expr.Position(), hint, "expected type instant vector in aggregation expression, got %s", typ)
+	}
+	if p.peek().typ == itemComma && expr.Param == nil {
+		p.errorf(p.peek(), "%s does not take a parameter", expr.Op)
+	}
+	p.expect(itemRightParen, "\")\"")
+
+	if typ := p.peek().typ; typ == itemBy || typ == itemWithout {
+		k := p.next()
+		if grouping {
+			p.errorf(k, "grouping already given in front of the aggregation")
+		}
+		expr.Without = typ == itemWithout
+		expr.Grouping = p.labelList()
+	}
+
+	expr.Pos = PositionRange{t.pos, p.lastEnd}
+	return expr
+}
+
+func aggregationExample(op string) string {
+	if op == "count_values" {
+		return `count_values("value", x)`
+	}
+	return op + "(5, x)"
+}
+
+func (p *parser) call() Expr {
+	t := p.next()
+	f, ok := Functions[t.val]
+	if !ok {
+		err := newError(p.input, t.pos, t.end(), "unknown function %q", t.val)
+		err.Unknown = true
+		panic(err)
+	}
+
+	p.expect(itemLeftParen, "\"(\"")
+	expr := &Call{
+		Func: f,
+	}
+	for p.peek().typ != itemRightParen {
+		expr.Args = append(expr.Args, p.expr(0))
+		if p.peek().typ != itemRightParen {
+			p.expect(itemComma, "\",\" or \")\"")
+		}
+	}
+	p.next()
+	expr.Pos = PositionRange{t.pos, p.lastEnd}
+
+	p.checkCall(expr)
+	return expr
+}
+
+func (p *parser) checkCall(call *Call) {
+	f := call.Func
+	min, max := len(f.ArgTypes)-f.Optional, len(f.ArgTypes)
+	if f.Variadic {
+		max = -1
+	}
+	if len(call.Args) < min || (max >= 0 && len(call.Args) > max) {
+		expected := strconv.Itoa(min)
+		switch {
+		case max < 0:
+			expected = "at least " + expected
+		case max != min:
+			expected = fmt.Sprintf("%d to %d", min, max)
+		}
+		p.errorHint(call.Pos, "usage: "+f.Signature(), "expected %s argument(s) in call to %q, got %d", expected, f.Name, len(call.Args))
+	}
+
+	for i, arg := range call.Args {
+		want := f.ArgTypes[len(f.ArgTypes)-1]
+		if i < len(f.ArgTypes) {
+			want = f.ArgTypes[i]
+		}
+
+		got := arg.Type()
+		if got == want {
+			continue
+		}
+
+		hint := ""
+		switch {
+		case want == ValueTypeMatrix && got == ValueTypeVector:
+			if _, ok := arg.(*VectorSelector); ok {
+				hint = fmt.Sprintf("%s needs a range, for example %s(%s[5m])", f.Name, f.Name, p.text(arg))
+			} else {
+				hint = fmt.Sprintf("use a subquery to get a range vector, for example %s((%s)[5m:1m])", f.Name, p.text(arg))
+			}
+		case want == ValueTypeVector && got == ValueTypeMatrix:
+			hint = fmt.Sprintf("%s expects an instant vector, remove the range or use a function like rate first", f.Name)
+		}
+		p.errorHint(arg.Position(), hint, "expected type %s in call to function %q, got %s", want, f.Name, got)
+	}
+}
+
+// selector parses a vector selector like up{job="prometheus"}.
+func (p *parser) selector() Expr {
+	start := p.peek().pos
+	sel := &VectorSelector{}
+	if t := p.peek(); t.typ == itemIdentifier {
+		p.next()
+		sel.Name = t.val
+	}
+
+	if p.peek().typ == itemLeftBrace {
+		p.next()
+		for p.peek().typ != itemRightBrace {
+			sel.Matchers = append(sel.Matchers, p.matcher(sel))
+			if p.peek().typ != itemRightBrace {
+				p.expect(itemComma, "\",\" or \"}\"")
+			}
+		}
+		p.next()
+	}
+	sel.Pos = PositionRange{start, p.lastEnd}
+
+	if sel.Name == "" {
+		for _, m := range sel.Matchers {
+			if !m.matchesEmpty() {
+				return sel
+			}
+		}
+		p.errorHint(sel.Pos, "add a metric name or a matcher like job=\"prometheus\"", "vector selector must contain at least one non-empty matcher")
+	}
+
+	return sel
+}
+
+func (p *parser) matcher(sel *VectorSelector) *LabelMatcher {
+	name := p.labelName()
+
+	op := p.next()
+	var typ MatchType
+	switch op.typ {
+	case itemAssign:
+		typ = MatchEqual
+	case itemNEQ:
+		typ = MatchNotEqual
+	case itemEQLRegex:
+		typ = MatchRegexp
+	case itemNEQRegex:
+		typ = MatchNotRegexp
+	case itemEQL:
+		p.errorHint(PositionRange{op.pos, op.end()}, "use = for label matchers", "unexpected \"==\" in label matcher")
+	default:
+		p.unexpected(op, "label matching operator")
+	}
+
+	value := p.expect(itemString, "string")
+	m := &LabelMatcher{
+		Name:  name.val,
+		Type:  typ,
+		Value: p.unquote(value),
+	}
+
+	if typ == MatchRegexp || typ == MatchNotRegexp {
+		if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+			p.errorf(value, "not a valid regular expression: %s", err)
+		}
+	}
+
+	if m.Name == "__name__" && sel.Name != "" {
+		p.errorf(name, "metric name must not be set twice: %q", sel.Name)
+	}
+
+	return m
+}
+
+func (m *LabelMatcher) matchesEmpty() bool {
+	switch m.Type {
+	case MatchEqual:
+		return m.Value == ""
+	case MatchNotEqual:
+		return m.Value != ""
+	}
+
+	matches := regexp.MustCompile("^(?:" + m.Value + ")$").MatchString("")
+	if m.Type == MatchRegexp {
+		return matches
+	}
+	return !matches
+}
+
+// postfix parses ranges, subqueries and modifiers following an expression.
+func (p *parser) postfix(expr Expr) Expr {
+	for {
+		switch p.peek().typ {
+		case itemLeftBracket:
+			expr = p.rangeOrSubquery(expr)
+		case itemOffset:
+			p.offset(expr)
+		case itemAt:
+			p.at(expr)
+		default:
+			return expr
+		}
+	}
+}
+
+func (p *parser) rangeOrSubquery(expr Expr) Expr {
+	open := p.next()
+	rng := p.duration()
+
+	if p.peek().typ == itemRightBracket {
+		p.next()
+		sel, ok := expr.(*VectorSelector)
+		if !ok {
+			p.errorHint(PositionRange{open.pos, p.lastEnd}, fmt.Sprintf("use a subquery, for example (%s)[%s:]", p.text(expr), p.input[open.pos+1:p.lastEnd-1]),
+				"ranges only allowed for vector selectors")
+		}
+		if sel.Offset != 0 || sel.At != "" {
+			p.errorf(open, "no offset or @ modifiers allowed before range")
+		}
+
+		return &MatrixSelector{
+			Vector: sel,
+			Range:  rng,
+			Pos:    PositionRange{expr.Position().Start, p.lastEnd},
+		}
+	}
+
+	p.expect(itemColon, "\"]\" or \":\"")
+	sub := &SubqueryExpr{
+		Expr:  expr,
+		Range: rng,
+	}
+	if p.peek().typ != itemRightBracket {
+		sub.Step = p.duration()
+	}
+	p.expect(itemRightBracket, "\"]\"")
+	sub.Pos = PositionRange{expr.Position().Start, p.lastEnd}
+
+	if typ := expr.Type(); typ != ValueTypeVector {
+		p.errorHint(sub.Pos, "", "subquery is only allowed on instant vector, got %s", typ)
+	}
+
+	return sub
+}
+
+func (p *parser) duration() time.Duration {
+	t := p.expect(itemDuration, "duration")
+	d, err := ParseDuration(t.val)
+	if err != nil {
+		p.errorf(t, "%s", err)
+	}
+	if d == 0 {
+		p.errorf(t, "duration must be greater than 0")
+	}
+	return d
+}
+
+// modifiers returns pointers to the offset and @ modifier of the expression.
+func (p *parser) modifiers(expr Expr, t item) (*time.Duration, *string) {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		return &e.Offset, &e.At
+	case *MatrixSelector:
+		return &e.Offset, &e.At
+	case *SubqueryExpr:
+		return &e.Offset, &e.At
+	}
+
+	p.errorf(t, "%s modifier must be preceded by a vector selector, range vector selector or subquery", t.val)
+	return nil, nil
+}
+
+func (p *parser) offset(expr Expr) {
+	t := p.next()
+	offset, _ := p.modifiers(expr, t)
+	if *offset != 0 {
+		p.errorf(t, "offset may not be set multiple times")
+	}
+
+	negative := false
+	if p.peek().typ == itemSUB {
+		p.next()
+		negative = true
+	}
+
+	*offset = p.duration()
+	if negative {
+		*offset = -*offset
+	}
+	p.extend(expr)
+}
+
+func (p *parser) at(expr Expr) {
+	t := p.next()
+	_, at := p.modifiers(expr, t)
+	if *at != "" {
+		p.errorf(t, "@ may not be set multiple times")
+	}
+
+	v := p.next()
+	switch {
+	case v.typ == itemNumber:
+		*at = v.val
+	case v.typ == itemIdentifier && (v.val == "start" || v.val == "end"):
+		p.expect(itemLeftParen, "\"(\"")
+		p.expect(itemRightParen, "\")\"")
+		*at = v.val + "()"
+	default:
+		p.unexpected(v, "timestamp, start() or end()")
+	}
+	p.extend(expr)
+}
+
+// extend sets the end of the expression to the end of the last token.
+func (p *parser) extend(expr Expr) {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		e.Pos.End = p.lastEnd
+	case *MatrixSelector:
+		e.Pos.End = p.lastEnd
+	case *SubqueryExpr:
+		e.Pos.End = p.lastEnd
+	}
+}
+
+var durationPartRegex = regexp.MustCompile(`([0-9]+)(ms|[smhdwy])`)
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// ParseDuration parses a PromQL duration like 5m or 1h30m.
+func ParseDuration(s string) (time.Duration, error) {
+	if !durationRegex.MatchString(s) || durationRegex.FindString(s) != s {
+		return 0, fmt.Errorf("not a valid duration: %s", s)
+	}
+
+	var d time.Duration
+	for _, part := range durationPartRegex.FindAllStringSubmatch(s, -1) {
+		n, err := strconv.ParseInt(part[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a valid duration: %s", s)
+		}
+		d += time.Duration(n) * durationUnits[part[2]]
+	}
+	return d, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package promql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExprValid(t *testing.T) {
+	for _, test := range []struct {
+		desc  string
+		input string
+		typ   ValueType
+	}{
+		{"number", "1.5", ValueTypeScalar},
+		{"hex number", "0x1f", ValueTypeScalar},
+		{"inf", "-Inf", ValueTypeScalar},
+		{"string", `"text"`, ValueTypeString},
+		{"selector", "up", ValueTypeVector},
+		{"matchers", `up{job="prometheus",instance!~'localhost:.*',}`, ValueTypeVector},
+		{"only matchers", `{__name__=~"node_.+"}`, ValueTypeVector},
+		{"keyword label", `up{on="a"}`, ValueTypeVector},
+		{"recording rule", "job:http_requests:rate5m", ValueTypeVector},
+		{"range", "http_requests_total[5m]", ValueTypeMatrix},
+		{"compound duration", "http_requests_total[1h30m]", ValueTypeMatrix},
+		{"offset", "rate(http_requests_total[5m] offset -1h)", ValueTypeVector},
+		{"at modifier", "up @ end()", ValueTypeVector},
+		{"subquery", "max_over_time(rate(http_requests_total[5m])[1h:1m])", ValueTypeVector},
+		{"subquery without step", "max_over_time(deriv(x[5m])[1h:])", ValueTypeVector},
+		{"aggregation", "sum by (job) (rate(http_requests_total[5m]))", ValueTypeVector},
+		{"grouping after", "sum(rate(http_requests_total[5m])) without (instance)", ValueTypeVector},
+		{"parameter", "topk(5, up)", ValueTypeVector},
+		{"count_values", `count_values("version", build_info)`, ValueTypeVector},
+		{"histogram", "histogram_quantile(0.9, sum by (le) (rate(x_bucket[5m])))", ValueTypeVector},
+		{"optional argument", "round(x) + round(x, 0.5)", ValueTypeVector},
+		{"variadic", `label_join(up, "dst", ",", "a", "b", "c")`, ValueTypeVector},
+		{"scalar function", "time() - 60", ValueTypeScalar},
+		{"precedence", "1 + 2 * 3 ^ 2 ^ 2", ValueTypeScalar},
+		{"unary", "-up", ValueTypeVector},
+		{"scalar comparison", "1 < bool 2", ValueTypeScalar},
+		{"vector matching", "a / on (job) group_left (team) b", ValueTypeVector},
+		{"set operator", "up == 0 unless on (job) absent(up)", ValueTypeVector},
+		{"comments", "sum( # total\n  up\n)", ValueTypeVector},
+		{"metric named like aggregation", "count", ValueTypeVector},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			expr, err := ParseExpr(test.input)
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			if typ := expr.Type(); typ != test.typ {
+				t.Errorf("got type %q, wanted %q", typ, test.typ)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		input   string
+		line    int
+		column  int
+		message string
+		hint    string
+		unknown bool
+	}{
+		{
+			desc:    "empty",
+			input:   "",
+			line:    1,
+			column:  1,
+			message: "no expression found in input",
+		},
+		{
+			desc:    "missing range",
+			input:   "rate(http_requests_total)",
+			line:    1,
+			column:  6,
+			message: `expected type range vector in call to function "rate", got instant vector`,
+			hint:    "rate(http_requests_total[5m])",
+		},
+		{
+			desc:    "missing range of expression",
+			input:   "sum(\n  rate(sum(x))\n)",
+			line:    2,
+			column:  8,
+			message: `expected type range vector in call to function "rate", got instant vector`,
+			hint:    "rate((sum(x))[5m:1m])",
+		},
+		{
+			desc:    "by after function",
+			input:   "rate(x[5m]) by (job)",
+			line:    1,
+			column:  13,
+			message: `unexpected "by" after call of function "rate"`,
+			hint:    "sum by (job) (rate(x[5m]))",
+		},
+		{
+			desc:    "unclosed bracket",
+			input:   "sum(up",
+			line:    1,
+			column:  7,
+			message: `unexpected end of input, expected ")"`,
+		},
+		{
+			desc:    "unterminated string",
+			input:   `up{job="a}`,
+			line:    1,
+			column:  8,
+			message: "unterminated quoted string",
+		},
+		{
+			desc:    "unknown function",
+			input:   "rated(x[5m])",
+			line:    1,
+			column:  1,
+			message: `unknown function "rated"`,
+			unknown: true,
+		},
+		{
+			desc:    "function names are case-sensitive",
+			input:   "RATE(x[5m])",
+			line:    1,
+			column:  1,
+			message: `unknown function "RATE"`,
+			unknown: true,
+		},
+		{
+			desc:    "wrong number of arguments",
+			input:   "histogram_quantile(x)",
+			line:    1,
+			column:  1,
+			message: `expected 2 argument(s) in call to "histogram_quantile", got 1`,
+			hint:    "histogram_quantile(scalar, instant-vector) instant-vector",
+		},
+		{
+			desc:    "double equals in matcher",
+			input:   `up{job=="a"}`,
+			line:    1,
+			column:  7,
+			message: `unexpected "==" in label matcher`,
+		},
+		{
+			desc:    "invalid regex",
+			input:   `up{job=~"("}`,
+			line:    1,
+			column:  9,
+			message: "not a valid regular expression",
+		},
+		{
+			desc:    "empty matchers",
+			input:   `{job=""}`,
+			line:    1,
+			column:  1,
+			message: "vector selector must contain at least one non-empty matcher",
+		},
+		{
+			desc:    "range on expression",
+			input:   "sum(x)[5m]",
+			line:    1,
+			column:  7,
+			message: "ranges only allowed for vector selectors",
+			hint:    "(sum(x))[5m:]",
+		},
+		{
+			desc:    "aggregation of range",
+			input:   "sum(x[5m])",
+			line:    1,
+			column:  5,
+			message: "expected type instant vector in aggregation expression, got range vector",
+		},
+		{
+			desc:    "scalar comparison",
+			input:   "1 > 2",
+			line:    1,
+			column:  3,
+			message: "comparisons between scalars must use bool modifier",
+		},
+		{
+			desc:    "bad duration",
+			input:   "x[5mm]",
+			line:    1,
+			column:  3,
+			message: `bad number or duration syntax: "5mm"`,
+		},
+		{
+			desc:    "trailing operator",
+			input:   "up /",
+			line:    1,
+			column:  5,
+			message: "unexpected end of input, expected expression",
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseExpr(test.input)
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got error %v, wanted *ParseError", err)
+			}
+
+			if parseErr.Line != test.line || parseErr.Column != test.column {
+				t.Errorf("got position %d:%d, wanted %d:%d", parseErr.Line, parseErr.Column, test.line, test.column)
+			}
+
+			if !strings.HasPrefix(parseErr.Message, test.message) {
+				t.Errorf("got message %q, wanted %q", parseErr.Message, test.message)
+			}
+
+			if !strings.Contains(parseErr.Hint, test.hint) {
+				t.Errorf("got hint %q, wanted %q", parseErr.Hint, test.hint)
+			}
+
+			if parseErr.Unknown != test.unknown {
+				t.Errorf("got unknown %v, wanted %v", parseErr.Unknown, test.unknown)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		out   string
+	}{
+		{"5m", "5m0s"},
+		{"1h30m", "1h30m0s"},
+		{"1d", "24h0m0s"},
+		{"500ms", "500ms"},
+	} {
+		d, err := ParseDuration(test.input)
+		if err != nil {
+			t.Errorf("%s: got error: %s", test.input, err)
+			continue
+		}
+
+		if d.String() != test.out {
+			t.Errorf("%s: got %s, wanted %s", test.input, d, test.out)
+		}
+	}
+}
+
+func TestLex(t *testing.T) {
+	items, err := lex(`a{b=~"c"}[5m:1m] >= 1`)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	var values []string
+	for _, i := range items {
+		values = append(values, i.val)
+	}
+
+	expected := []string{"a", "{", "b", "=~", `"c"`, "}", "[", "5m", ":", "1m", "]", ">=", "1", ""}
+	if strings.Join(values, " ") != strings.Join(expected, " ") {
+		t.Errorf("got %q, wanted %q", values, expected)
+	}
+}
@@ -2,10 +2,16 @@ package scaffold
 
 import (
 	"context"
+	"encoding/json"
 )
 
 type DisplayFunc func(data *DisplayData, update bool)
 
+// InputFunc requests input from the user using input_request.
+// If password is true, the frontend does not show the input.
+// It returns ErrStdinNotAllowed if the frontend does not support input requests.
+type InputFunc func(prompt string, password bool) (string, error)
+
 // RequestHandlers is the interface to define handlers to handle Jupyter messages.
 // Except for HandleGoFmt, all mesages are defined in
 // http://jupyter-client.readthedocs.io/en/latest/messaging.html
@@ -13,15 +19,18 @@ type RequestHandlers interface {
 	HandleKernelInfo() KernelInfo
 	// HandleExecuteRequest handles execute_request.
 	// writeStream sends stdout/stderr texts and writeDisplayData sends display_data
-	// (or update_display_data if update is true) to the client.
+	// (or update_display_data if update is true) to the client. readInput requests input from the user.
 	HandleExecuteRequest(ctx context.Context,
 		req *ExecuteRequest,
 		writeStream func(name, text string),
-		writeDisplayData DisplayFunc) *ExecuteResult
+		writeDisplayData DisplayFunc,
+		readInput InputFunc) *ExecuteResult
 	HandleComplete(req *CompleteRequest) *CompleteReply
 	HandleInspect(req *InspectRequest) *InspectReply
 	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#code-completeness
 	HandleIsComplete(req *IsCompleteRequest) *IsCompleteReply
+	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#history
+	HandleHistory(req *HistoryRequest) *HistoryReply
 }
 
 // KernelInfo is a reply to kernel_info_request.
@@ -45,6 +54,10 @@ type KernelLanguageInfo struct {
 	Version       string `json:"version"`
 	Mimetype      string `json:"mimetype"`
 	FileExtension string `json:"file_extension"`
+	// CodemirrorMode is the name of the CodeMirror mode used for highlighting in the notebook.
+	CodemirrorMode string `json:"codemirror_mode,omitempty"`
+	// PygmentsLexer is the name of the Pygments lexer used for highlighting by nbconvert.
+	PygmentsLexer string `json:"pygments_lexer,omitempty"`
 }
 
 // ExecuteRequest is the struct to represent execute_request.
@@ -54,13 +67,37 @@ type ExecuteRequest struct {
 	StoreHistory bool   `json:"store_history"`
 	AllowStdin   bool   `json:"allow_stdin"`
 	StopOnError  bool   `json:"stop_on_error"`
+
+	// ExecutionCount is the count of this execution, set by the scaffold before the request is handled.
+	ExecutionCount int `json:"-"`
+	// CellID identifies the executed cell, if the frontend sends it in the metadata of the request.
+	CellID string `json:"-"`
+}
+
+// InterruptHandler is implemented by RequestHandlers which run tasks in the background,
+// which are not cancelled together with the context of execute_request.
+type InterruptHandler interface {
+	// HandleInterrupt stops the background tasks. It is called on interrupts and before the kernel shuts down.
+	HandleInterrupt()
+}
+
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#kernel-shutdown
+type shutdownRequest struct {
+	Restart bool `json:"restart"`
+}
+
+type shutdownReply struct {
+	Status  string `json:"status"`
+	Restart bool   `json:"restart"`
 }
 
 // See http://jupyter-client.readthedocs.io/en/stable/messaging.html#request-reply
 type errorReply struct {
-	Status string `json:"status"` // status must be always "error"
-	Ename  string `json:"ename,omitempty"`
-	Evalue string `json:"evalue,omitempty"`
+	Status         string   `json:"status"` // status must be always "error"
+	ExecutionCount int      `json:"execution_count"`
+	Ename          string   `json:"ename"`
+	Evalue         string   `json:"evalue"`
+	Traceback      []string `json:"traceback"`
 }
 
 // InspectRequest represents inspect_request.
@@ -116,14 +153,30 @@ type CompleteReply struct {
 	Status string `json:"status"`
 }
 
-// ExecuteResult represents execute_result.
-// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-results
+// ExecuteResult is the result of handling execute_request. It is sent as execute_reply.
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#execute
 type ExecuteResult struct {
 	Status         string `json:"status"`
-	ExecutionCount int    `json:"execution_count,omitempty"`
-	// data and metadata are omitted because they are covered by DisplayData.
+	ExecutionCount int    `json:"execution_count"`
+
+	// Payload contains actions for the frontend, like set_next_input.
+	Payload []Payload `json:"payload,omitempty"`
+
+	// Result is published as execute_result, if the execution succeeded and was not silent.
+	// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#id6
+	Result *DisplayData `json:"-"`
+
+	// Ename, Evalue and Traceback describe the error if status is 'error'.
+	// They are published as error message and sent in execute_reply.
+	Ename     string   `json:"-"`
+	Evalue    string   `json:"-"`
+	Traceback []string `json:"-"`
 }
 
+// Payload is a payload of execute_reply.
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#payloads-deprecated
+type Payload map[string]interface{}
+
 // DisplayData represents display_data defined in http://jupyter-client.readthedocs.io/en/latest/messaging.html#display-data
 //
 // Jupyter Notebook does not accept display_data with "metadata: null" (Failed validating u'type' in display_data[u'properties'][u'metadata'] in Jupyter notebook).
@@ -160,3 +213,59 @@ type IsCompleteReply struct {
 	// field does not exist.
 	Indent string `json:"indent"`
 }
+
+// HistoryRequest represents history_request.
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#history
+type HistoryRequest struct {
+	// If true, also return output history in the resulting dict.
+	Output bool `json:"output"`
+	// If true, return the raw input history, else the transformed input.
+	Raw bool `json:"raw"`
+	// One of 'range', 'tail' or 'search'.
+	HistAccessType string `json:"hist_access_type"`
+	// If hist_access_type is 'range', get a range of input cells. session
+	// is a number counting up each time the kernel starts; you can give
+	// a positive session number, or a negative number to count back from
+	// the current session.
+	Session int `json:"session"`
+	// start and stop are line (cell) numbers within that session.
+	Start int `json:"start"`
+	Stop  int `json:"stop"`
+	// If hist_access_type is 'tail' or 'search', get the last n cells.
+	N int `json:"n"`
+	// If hist_access_type is 'search', get cells matching the specified glob
+	// pattern (with * and ? as wildcards).
+	Pattern string `json:"pattern"`
+	// If hist_access_type is 'search' and unique is true, do not
+	// include duplicated history.
+	Unique bool `json:"unique"`
+}
+
+// HistoryReply represents history_reply.
+type HistoryReply struct {
+	Status  string         `json:"status"`
+	History []HistoryEntry `json:"history"`
+}
+
+// HistoryEntry is an executed cell, it is sent as (session, line_number, input).
+// If IncludeOutput is set, it is sent as (session, line_number, (input, output)) and an empty output is null.
+type HistoryEntry struct {
+	Session       int
+	Line          int
+	Input         string
+	IncludeOutput bool
+	Output        string
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e HistoryEntry) MarshalJSON() ([]byte, error) {
+	if !e.IncludeOutput {
+		return json.Marshal([]interface{}{e.Session, e.Line, e.Input})
+	}
+
+	var output interface{}
+	if e.Output != "" {
+		output = e.Output
+	}
+	return json.Marshal([]interface{}{e.Session, e.Line, []interface{}{e.Input, output}})
+}
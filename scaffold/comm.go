@@ -0,0 +1,230 @@
+package scaffold
+
+import (
+	"context"
+	"sync"
+)
+
+// CommHandlers is implemented by RequestHandlers which support comms.
+// Comms are channels for custom messages between the kernel and the frontend,
+// see http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+type CommHandlers interface {
+	// RegisterComms is called once when the server is created. The handlers register their
+	// comm targets and can keep the manager to open comms from the kernel.
+	RegisterComms(comms *CommManager)
+}
+
+// CommHandler handles the comms of a target.
+// The handler methods are called sequentially with execute_requests. Like for executions,
+// the context is cancelled by interrupt_request and when the kernel shuts down.
+type CommHandler interface {
+	// HandleOpen is called when the frontend opens a comm to the target.
+	// If it returns an error, the comm is closed again.
+	HandleOpen(ctx context.Context, comm *Comm, data map[string]interface{}) error
+	// HandleMessage is called for every comm_msg sent by the frontend.
+	HandleMessage(ctx context.Context, comm *Comm, data map[string]interface{})
+	// HandleClose is called when the frontend closes the comm.
+	HandleClose(ctx context.Context, comm *Comm, data map[string]interface{})
+}
+
+type commMessage struct {
+	CommID     string                 `json:"comm_id"`
+	TargetName string                 `json:"target_name,omitempty"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+type commInfoRequest struct {
+	TargetName string `json:"target_name"`
+}
+
+type commInfo struct {
+	TargetName string `json:"target_name"`
+}
+
+type commInfoReply struct {
+	Status string              `json:"status"`
+	Comms  map[string]commInfo `json:"comms"`
+}
+
+// A Comm is an open comm between the kernel and the frontend.
+type Comm struct {
+	ID         string
+	TargetName string
+
+	manager *CommManager
+	handler CommHandler
+	// parent is the last message received for the comm, which is used as parent of the messages sent by the kernel.
+	parent *message
+}
+
+// Send sends a comm_msg to the frontend.
+func (c *Comm) Send(data map[string]interface{}) {
+	c.manager.send("comm_msg", c, data)
+}
+
+// Display sends display_data (or update_display_data if update is true) with the last message
+// received on the comm as parent. It can be used to update outputs when the frontend sends a message.
+func (c *Comm) Display(data *DisplayData, update bool) {
+	c.manager.mutex.Lock()
+	parent := c.parent
+	c.manager.mutex.Unlock()
+	c.manager.iopub.sendDisplayData(data, parent, update)
+}
+
+// Close closes the comm and tells the frontend about it.
+func (c *Comm) Close(data map[string]interface{}) {
+	c.manager.remove(c.ID)
+	c.manager.send("comm_close", c, data)
+}
+
+// publisher sends messages on iopub. It is implemented by iopubSocket and by a recorder in the tests.
+type publisher interface {
+	publish(msgType string, content interface{}, parent *message)
+	sendDisplayData(data *DisplayData, parent *message, update bool)
+}
+
+// CommManager keeps track of the comm targets and open comms of the kernel.
+type CommManager struct {
+	iopub publisher
+
+	mutex   sync.Mutex
+	targets map[string]CommHandler
+	comms   map[string]*Comm
+}
+
+func newCommManager(iopub publisher) *CommManager {
+	return &CommManager{
+		iopub:   iopub,
+		targets: make(map[string]CommHandler),
+		comms:   make(map[string]*Comm),
+	}
+}
+
+// RegisterTarget registers the handler for comms to the target.
+func (m *CommManager) RegisterTarget(name string, handler CommHandler) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.targets[name] = handler
+}
+
+// Open opens a comm from the kernel to a target registered in the frontend.
+// Messages which the frontend sends on the comm are handled by handler.
+func (m *CommManager) Open(target string, data map[string]interface{}, handler CommHandler) *Comm {
+	comm := &Comm{
+		ID:         genMsgID(),
+		TargetName: target,
+		manager:    m,
+		handler:    handler,
+		parent:     &message{},
+	}
+	m.mutex.Lock()
+	m.comms[comm.ID] = comm
+	m.mutex.Unlock()
+
+	m.send("comm_open", comm, data)
+	return comm
+}
+
+// Comms returns the open comms of a target.
+func (m *CommManager) Comms(target string) []*Comm {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var comms []*Comm
+	for _, comm := range m.comms {
+		if comm.TargetName == target {
+			comms = append(comms, comm)
+		}
+	}
+	return comms
+}
+
+func (m *CommManager) remove(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.comms, id)
+}
+
+func (m *CommManager) send(msgType string, comm *Comm, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	content := &commMessage{
+		CommID: comm.ID,
+		Data:   data,
+	}
+	if msgType == "comm_open" {
+		content.TargetName = comm.TargetName
+	}
+
+	m.mutex.Lock()
+	parent := comm.parent
+	m.mutex.Unlock()
+	m.iopub.publish(msgType, content, parent)
+}
+
+// handle handles comm_open, comm_msg and comm_close messages of the frontend.
+func (m *CommManager) handle(ctx context.Context, msg *message) {
+	content := msg.Content.(*commMessage)
+	switch msg.Header.MsgType {
+	case "comm_open":
+		m.mutex.Lock()
+		handler, ok := m.targets[content.TargetName]
+		comm := &Comm{
+			ID:         content.CommID,
+			TargetName: content.TargetName,
+			manager:    m,
+			handler:    handler,
+			parent:     msg,
+		}
+		if ok {
+			m.comms[comm.ID] = comm
+		}
+		m.mutex.Unlock()
+
+		if !ok {
+			log.Warningf("comm_open for unknown target %q", content.TargetName)
+			m.send("comm_close", comm, nil)
+			return
+		}
+		if err := handler.HandleOpen(ctx, comm, content.Data); err != nil {
+			log.Errorf("Failed to open comm to %q: %v", content.TargetName, err)
+			comm.Close(nil)
+		}
+	case "comm_msg", "comm_close":
+		m.mutex.Lock()
+		comm, ok := m.comms[content.CommID]
+		if ok {
+			comm.parent = msg
+		}
+		if msg.Header.MsgType == "comm_close" {
+			delete(m.comms, content.CommID)
+		}
+		m.mutex.Unlock()
+
+		switch {
+		case !ok:
+			log.Warningf("%s for unknown comm %q", msg.Header.MsgType, content.CommID)
+		case comm.handler == nil:
+		case msg.Header.MsgType == "comm_msg":
+			comm.handler.HandleMessage(ctx, comm, content.Data)
+		default:
+			comm.handler.HandleClose(ctx, comm, content.Data)
+		}
+	}
+}
+
+// info returns the open comms, optionally only the ones of a target.
+func (m *CommManager) info(req *commInfoRequest) *commInfoReply {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reply := &commInfoReply{
+		Status: "ok",
+		Comms:  make(map[string]commInfo),
+	}
+	for id, comm := range m.comms {
+		if req.TargetName == "" || comm.TargetName == req.TargetName {
+			reply.Comms[id] = commInfo{TargetName: comm.TargetName}
+		}
+	}
+	return reply
+}
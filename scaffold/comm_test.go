@@ -0,0 +1,102 @@
+package scaffold
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type publishedMessage struct {
+	msgType string
+	content interface{}
+}
+
+type recordingPublisher struct {
+	messages []publishedMessage
+}
+
+func (p *recordingPublisher) publish(msgType string, content interface{}, parent *message) {
+	p.messages = append(p.messages, publishedMessage{msgType, content})
+}
+
+func (p *recordingPublisher) sendDisplayData(data *DisplayData, parent *message, update bool) {
+	p.messages = append(p.messages, publishedMessage{"display_data", data})
+}
+
+type recordingHandler struct {
+	closed []string
+}
+
+func (h *recordingHandler) HandleOpen(ctx context.Context, comm *Comm, data map[string]interface{}) error {
+	return nil
+}
+
+func (h *recordingHandler) HandleMessage(ctx context.Context, comm *Comm, data map[string]interface{}) {
+}
+
+func (h *recordingHandler) HandleClose(ctx context.Context, comm *Comm, data map[string]interface{}) {
+	h.closed = append(h.closed, comm.ID)
+}
+
+func commRequest(msgType, id, target string) *message {
+	msg := &message{Content: &commMessage{CommID: id, TargetName: target}}
+	msg.Header.MsgType = msgType
+	return msg
+}
+
+func TestCommOpenUnknownTarget(t *testing.T) {
+	iopub := &recordingPublisher{}
+	m := newCommManager(iopub)
+	m.handle(context.Background(), commRequest("comm_open", "1", "unknown"))
+
+	want := []publishedMessage{
+		{"comm_close", &commMessage{CommID: "1", Data: map[string]interface{}{}}},
+	}
+	if !reflect.DeepEqual(iopub.messages, want) {
+		t.Errorf("got messages %+v, wanted %+v", iopub.messages, want)
+	}
+	if len(m.comms) != 0 {
+		t.Errorf("got open comms %v, wanted none", m.comms)
+	}
+}
+
+func TestCommClose(t *testing.T) {
+	iopub := &recordingPublisher{}
+	handler := &recordingHandler{}
+	m := newCommManager(iopub)
+	m.RegisterTarget("target", handler)
+	m.handle(context.Background(), commRequest("comm_open", "1", "target"))
+	m.handle(context.Background(), commRequest("comm_close", "1", ""))
+
+	if !reflect.DeepEqual(handler.closed, []string{"1"}) {
+		t.Errorf("got closed comms %q, wanted the opened comm", handler.closed)
+	}
+	if len(m.comms) != 0 {
+		t.Errorf("got open comms %v, wanted none", m.comms)
+	}
+	if len(iopub.messages) != 0 {
+		t.Errorf("got messages %+v, wanted none", iopub.messages)
+	}
+}
+
+func TestCommInfo(t *testing.T) {
+	m := newCommManager(&recordingPublisher{})
+	m.RegisterTarget("a", &recordingHandler{})
+	m.RegisterTarget("b", &recordingHandler{})
+	m.handle(context.Background(), commRequest("comm_open", "1", "a"))
+	m.handle(context.Background(), commRequest("comm_open", "2", "b"))
+
+	for _, test := range []struct {
+		target string
+		want   map[string]commInfo
+	}{
+		{"", map[string]commInfo{"1": {TargetName: "a"}, "2": {TargetName: "b"}}},
+		{"a", map[string]commInfo{"1": {TargetName: "a"}}},
+		{"c", map[string]commInfo{}},
+	} {
+		reply := m.info(&commInfoRequest{TargetName: test.target})
+		if reply.Status != "ok" || !reflect.DeepEqual(reply.Comms, test.want) {
+			t.Errorf("target %q: got %+v, wanted comms %v", test.target, reply, test.want)
+		}
+	}
+}
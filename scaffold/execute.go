@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 const executeQueueSize = 1 << 8
@@ -13,25 +14,33 @@ type executeQueueItem struct {
 	sock *shellSocket
 }
 
-// executeQueue executes execute_requests sequentially.
+// executeQueue executes execute_requests and handles comm messages sequentially.
 // Although shellSocket in this package accepts request on a shell socket in parallel,
 // we shold not handle multiple execute_requests in parallel because the jupyter client sends
 // successive execute_requests to servers before previous execute_requests finishes.
 // A jupyter kernel is responsible to handle multiple execute_requests sequentially and
 // abort them if one of them fails.
 type executeQueue struct {
-	serverCtx  context.Context
-	queue      chan *executeQueueItem
-	iopub      *iopubSocket
-	handlers   RequestHandlers
+	serverCtx context.Context
+	queue     chan *executeQueueItem
+	iopub     *iopubSocket
+	stdin     *stdinSocket
+	comms     *CommManager
+	handlers  RequestHandlers
+	// currentCtx is the context of the running execute_request or comm message, it is guarded by mutex.
 	currentCtx *contextAndCancel
+	mutex      sync.Mutex
+	// executionCount is the count of the last execution, which was not silent.
+	executionCount int
 }
 
-func newExecuteQueue(ctx context.Context, iopub *iopubSocket, handlers RequestHandlers) *executeQueue {
+func newExecuteQueue(ctx context.Context, iopub *iopubSocket, stdin *stdinSocket, comms *CommManager, handlers RequestHandlers) *executeQueue {
 	return &executeQueue{
 		serverCtx: ctx,
 		queue:     make(chan *executeQueueItem, executeQueueSize),
 		iopub:     iopub,
+		stdin:     stdin,
+		comms:     comms,
 		handlers:  handlers,
 	}
 }
@@ -51,6 +60,10 @@ loop:
 		default:
 			break loop
 		}
+		if item.req.Header.MsgType != "execute_request" {
+			q.handleComm(item.req)
+			continue
+		}
 		err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
 			res := newMessageWithParent(item.req)
 			res.Header.MsgType = "execute_reply"
@@ -68,11 +81,57 @@ loop:
 	}
 }
 
-func (q *executeQueue) cancelCurrent() {
-	cur := q.currentCtx
-	if cur != nil {
-		cur.cancel()
+// cancelCurrent cancels the context of the running execute_request or comm message, if there is one.
+// It returns false if nothing is running.
+func (q *executeQueue) cancelCurrent() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.currentCtx == nil {
+		return false
+	}
+	q.currentCtx.cancel()
+	return true
+}
+
+func (q *executeQueue) setCurrent(cur *contextAndCancel) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.currentCtx = cur
+}
+
+// handleComm handles a comm message. Its context can be cancelled by interrupt_request like an execution.
+func (q *executeQueue) handleComm(req *message) {
+	err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
+		cur, cancel := context.WithCancel(ctx)
+		q.setCurrent(&contextAndCancel{cur, cancel})
+		defer func() {
+			cancel()
+			q.setCurrent(nil)
+		}()
+		q.comms.handle(cur, req)
+		return nil
+	}, req)
+	if err != nil {
+		log.Errorf("Failed to handle %s: %v", req.Header.MsgType, err)
+	}
+}
+
+// newErrorReply converts a failed execution to the content of execute_reply.
+func newErrorReply(result *ExecuteResult) *errorReply {
+	reply := &errorReply{
+		Status:         "error",
+		ExecutionCount: result.ExecutionCount,
+		Ename:          result.Ename,
+		Evalue:         result.Evalue,
+		Traceback:      result.Traceback,
+	}
+	if reply.Ename == "" {
+		reply.Ename = "Error"
 	}
+	if reply.Traceback == nil {
+		reply.Traceback = []string{}
+	}
+	return reply
 }
 
 // loop executes execute_requests sequentially.
@@ -84,17 +143,38 @@ loop:
 		select {
 		case item = <-q.queue:
 		case <-q.serverCtx.Done():
+			// Requests which were not executed yet are answered before the kernel shuts down.
+			q.abortQueue()
 			break loop
 		}
 
+		if item.req.Header.MsgType != "execute_request" {
+			// Comm messages are handled in the queue, so that they are not handled in parallel with executions.
+			q.handleComm(item.req)
+			continue
+		}
+
 		exReq := item.req.Content.(*ExecuteRequest)
+		// Silent executions are not counted, see
+		// http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-counter-prompt-number
+		if !exReq.Silent && exReq.StoreHistory {
+			q.executionCount++
+		}
+		exReq.ExecutionCount = q.executionCount
+		if metadata, ok := item.req.Metadata.(*map[string]interface{}); ok {
+			exReq.CellID, _ = (*metadata)["cellId"].(string)
+		}
+
 		err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
 			cur, cancel := context.WithCancel(ctx)
-			q.currentCtx = &contextAndCancel{cur, cancel}
+			q.setCurrent(&contextAndCancel{cur, cancel})
 			defer func() {
 				cancel()
-				q.currentCtx = nil
+				q.setCurrent(nil)
 			}()
+			if !exReq.Silent {
+				q.iopub.sendExecuteInput(exReq.Code, exReq.ExecutionCount, item.req)
+			}
 			result := q.handlers.HandleExecuteRequest(
 				cur,
 				exReq,
@@ -102,10 +182,28 @@ loop:
 					q.iopub.sendStream(name, text, item.req)
 				}, func(data *DisplayData, update bool) {
 					q.iopub.sendDisplayData(data, item.req, update)
+				}, func(prompt string, password bool) (string, error) {
+					if !exReq.AllowStdin {
+						return "", ErrStdinNotAllowed
+					}
+					return q.stdin.requestInput(cur, prompt, password, item.req)
 				})
+			if result == nil {
+				result = &ExecuteResult{Status: "ok"}
+			}
+			result.ExecutionCount = exReq.ExecutionCount
+
 			res := newMessageWithParent(item.req)
 			res.Header.MsgType = "execute_reply"
-			res.Content = &result
+			res.Content = result
+			switch {
+			case result.Status == "error":
+				reply := newErrorReply(result)
+				q.iopub.sendError(reply.Ename, reply.Evalue, reply.Traceback, item.req)
+				res.Content = reply
+			case result.Result != nil && !exReq.Silent:
+				q.iopub.sendExecuteResult(result.Result, result.ExecutionCount, item.req)
+			}
 			if err := item.sock.pushResult(res); err != nil {
 				log.Errorf("Failed to send execute_reply: %v", err)
 			}
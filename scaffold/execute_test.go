@@ -0,0 +1,72 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewErrorReply(t *testing.T) {
+	for _, test := range []struct {
+		desc   string
+		result *ExecuteResult
+		want   *errorReply
+	}{
+		{
+			desc: "error details",
+			result: &ExecuteResult{
+				Status:         "error",
+				ExecutionCount: 3,
+				Ename:          "SyntaxError",
+				Evalue:         "unexpected end of input",
+				Traceback:      []string{"sum(up", "      ^"},
+			},
+			want: &errorReply{
+				Status:         "error",
+				ExecutionCount: 3,
+				Ename:          "SyntaxError",
+				Evalue:         "unexpected end of input",
+				Traceback:      []string{"sum(up", "      ^"},
+			},
+		},
+		{
+			desc: "defaults",
+			result: &ExecuteResult{
+				Status: "error",
+				Evalue: "query failed",
+			},
+			want: &errorReply{
+				Status:    "error",
+				Ename:     "Error",
+				Evalue:    "query failed",
+				Traceback: []string{},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			reply := newErrorReply(test.result)
+			if !reflect.DeepEqual(reply, test.want) {
+				t.Errorf("got %+v, wanted %+v", reply, test.want)
+			}
+		})
+	}
+}
+
+func TestExecutionCountSent(t *testing.T) {
+	for _, content := range []interface{}{
+		&ExecuteResult{Status: "ok"},
+		newErrorReply(&ExecuteResult{Status: "error"}),
+	} {
+		data, err := json.Marshal(content)
+		if err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		if !strings.Contains(string(data), `"execution_count":0`) {
+			t.Errorf("got %s, wanted execution_count", data)
+		}
+	}
+}
@@ -2,12 +2,9 @@ package scaffold
 
 import (
 	"bytes"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 
 	zmq "github.com/pebbe/zmq4"
@@ -45,6 +42,16 @@ func newContentForMsgType(header *messageHeader) interface{} {
 		return &InspectRequest{}
 	case "is_complete_request":
 		return &IsCompleteRequest{}
+	case "history_request":
+		return &HistoryRequest{}
+	case "shutdown_request":
+		return &shutdownRequest{}
+	case "input_reply":
+		return &inputReply{}
+	case "comm_open", "comm_msg", "comm_close":
+		return &commMessage{}
+	case "comm_info_request":
+		return &commInfoRequest{}
 	}
 	return nil
 }
@@ -60,26 +67,15 @@ func unmarshalJSONToInterface(b []byte, i interface{}) (interface{}, error) {
 	return i, nil
 }
 
-func validateMessages(msgs [][]byte, key []byte) error {
+func validateMessages(msgs [][]byte, signer *signer) error {
 	if len(msgs) < 5 {
 		return fmt.Errorf("Too short messages: %d", len(msgs))
 	}
-	mac := hmac.New(sha256.New, key)
 	// header, parent header, metadata, and content are signed with hmac.
-	for _, msg := range msgs[1:5] {
-		mac.Write(msg)
-	}
-	// Decode the hex signature
-	sig := make([]byte, hex.DecodedLen(len(msgs[0])))
-	hex.Decode(sig, msgs[0])
-	// Verify the signature
-	if !hmac.Equal(mac.Sum(nil), sig) {
-		return errors.New("HMAC was invalid")
-	}
-	return nil
+	return signer.validate(msgs[0], msgs[1:5])
 }
 
-func (m *message) Unmarshal(bs [][]byte, key []byte) error {
+func (m *message) Unmarshal(bs [][]byte, signer *signer) error {
 	delimIdx := -1
 	for i, b := range bs {
 		if bytes.Equal(b, identityDelim) {
@@ -91,7 +87,7 @@ func (m *message) Unmarshal(bs [][]byte, key []byte) error {
 		return fmt.Errorf("Identity deliminator %s not found", identityDelim)
 	}
 	bodies := bs[delimIdx+1:]
-	if err := validateMessages(bodies, key); err != nil {
+	if err := validateMessages(bodies, signer); err != nil {
 		return err
 	}
 	m.Identity = bs[:delimIdx]
@@ -117,7 +113,7 @@ func (m *message) Unmarshal(bs [][]byte, key []byte) error {
 	return nil
 }
 
-func (m *message) Marshal(key []byte) (bs [][]byte, err error) {
+func (m *message) Marshal(signer *signer) (bs [][]byte, err error) {
 	bs = m.Identity
 	bs = append(bs, identityDelim)
 	chunks := []interface{}{&m.Header, &m.ParentHeader, m.Metadata, m.Content}
@@ -134,20 +130,12 @@ func (m *message) Marshal(key []byte) (bs [][]byte, err error) {
 		}
 		bodies = append(bodies, data)
 	}
-	mac := hmac.New(sha256.New, key)
-	for _, body := range bodies {
-		mac.Write(body)
-	}
-	sig := mac.Sum(nil)
-	hexSig := make([]byte, hex.EncodedLen(len(sig)))
-	hex.Encode(hexSig, sig)
-
-	bs = append(bs, hexSig)
+	bs = append(bs, signer.sign(bodies))
 	return append(bs, bodies...), nil
 }
 
-func (m *message) Send(sock *zmq.Socket, key []byte) error {
-	bs, err := m.Marshal(key)
+func (m *message) Send(sock *zmq.Socket, signer *signer) error {
+	bs, err := m.Marshal(signer)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal kernelinfo: %v", err)
 	}
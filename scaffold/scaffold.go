@@ -16,6 +16,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	zmq "github.com/pebbe/zmq4"
 	"github.com/sirupsen/logrus"
@@ -23,6 +24,13 @@ import (
 
 var log = logrus.New().WithField("package", "scaffold")
 
+// socketLinger is the time messages which were not sent yet are kept when a socket is closed.
+// It gives clients the chance to receive the last replies, like shutdown_reply, when the kernel exits.
+const socketLinger = time.Second
+
+// hbControlAddr is the address of the socket used to stop the heartbeat proxy.
+const hbControlAddr = "inproc://heartbeat-control"
+
 // ConnectionInfo stores the contents of the kernel connection file created by Jupyter.
 type connectionInfo struct {
 	StdinPort       int    `json:"stdin_port"`
@@ -35,6 +43,9 @@ type connectionInfo struct {
 	ShellPort       int    `json:"shell_port"`
 	Transport       string `json:"transport"`
 	IOPubPort       int    `json:"iopub_port"`
+
+	// signer signs the messages according to SignatureScheme and Key.
+	signer *signer
 }
 
 func readConnectionInfo(connectionFile string) (*connectionInfo, error) {
@@ -48,13 +59,37 @@ func readConnectionInfo(connectionFile string) (*connectionInfo, error) {
 		return nil, fmt.Errorf("Failed to parse %s: %v", connectionFile, err)
 	}
 	log.Infof("Connection info: %+v", cinfo)
+	if cinfo.signer, err = newSigner(cinfo.SignatureScheme, cinfo.Key); err != nil {
+		return nil, err
+	}
+	if cinfo.Key == "" {
+		log.Warning("Connection file contains no key, messages are not signed.")
+	}
 	return &cinfo, nil
 }
 
+// getAddr returns the ZMQ endpoint of a port. For the ipc transport the IP is the prefix of the socket files,
+// which are called "ip-port".
 func (ci *connectionInfo) getAddr(port int) string {
+	if ci.Transport == "ipc" {
+		return fmt.Sprintf("ipc://%s-%d", ci.IP, port)
+	}
 	return fmt.Sprintf("%s://%s:%d", ci.Transport, ci.IP, port)
 }
 
+// removeIPCFiles removes the socket files of the ipc transport, which can be left behind after the sockets are closed.
+func (ci *connectionInfo) removeIPCFiles() {
+	if ci.Transport != "ipc" {
+		return
+	}
+	for _, port := range []int{ci.ShellPort, ci.IOPubPort, ci.StdinPort, ci.ControlPort, ci.HBPort} {
+		path := fmt.Sprintf("%s-%d", ci.IP, port)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warningf("Failed to remove socket file %s: %v", path, err)
+		}
+	}
+}
+
 // A Server is a jupyter kernel server that that handles user commands forwarded from
 // Jupyter frontend servers.
 type Server struct {
@@ -65,11 +100,15 @@ type Server struct {
 	cancelCtx func()
 
 	// ZMQ sockets
+	zmqCtx  *zmq.Context
 	shell   *shellSocket
 	control *shellSocket
 	iopub   *iopubSocket
-	stdin   *zmq.Socket
+	stdin   *stdinSocket
 	hb      *zmq.Socket
+	// hbControl steers the heartbeat proxy, hbTerminate sends the command to stop it.
+	hbControl   *zmq.Socket
+	hbTerminate *zmq.Socket
 
 	// Attribute
 	connInfo *connectionInfo
@@ -100,8 +139,17 @@ func NewServer(connectionFile string, handlers RequestHandlers) (server *Server,
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create iopub socket: %v", err)
 	}
+	stdin, err := newStdinSocket(ctx, cinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	comms := newCommManager(iopub)
+	if commHandlers, ok := handlers.(CommHandlers); ok {
+		commHandlers.RegisterComms(comms)
+	}
 
-	execQueue := newExecuteQueue(serverCtx, iopub, handlers)
+	execQueue := newExecuteQueue(serverCtx, iopub, stdin, comms, handlers)
 	shell, err := newShellSocket(serverCtx, ctx, "shell", cinfo, iopub, handlers, cancelCtx, execQueue)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create shell socket: %v", err)
@@ -111,13 +159,6 @@ func NewServer(connectionFile string, handlers RequestHandlers) (server *Server,
 		return nil, fmt.Errorf("Failed to create control socket: %v", err)
 	}
 
-	stdin, err := ctx.NewSocket(zmq.ROUTER)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
-	}
-	if err := stdin.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
-		return nil, fmt.Errorf("Failed to bind shell socket: %v", err)
-	}
 	// Ref: Python version of HeartBeat
 	// https://github.com/ipython/ipykernel/blob/master/ipykernel/heartbeat.py
 	hb, err := ctx.NewSocket(zmq.REP)
@@ -127,17 +168,34 @@ func NewServer(connectionFile string, handlers RequestHandlers) (server *Server,
 	if err := hb.Bind(cinfo.getAddr(cinfo.HBPort)); err != nil {
 		return nil, fmt.Errorf("Failed to bind heartbeat socket: %v", err)
 	}
+	hbControl, err := ctx.NewSocket(zmq.PAIR)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open heartbeat control socket: %v", err)
+	}
+	if err := hbControl.Bind(hbControlAddr); err != nil {
+		return nil, fmt.Errorf("Failed to bind heartbeat control socket: %v", err)
+	}
+	hbTerminate, err := ctx.NewSocket(zmq.PAIR)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open heartbeat control socket: %v", err)
+	}
+	if err := hbTerminate.Connect(hbControlAddr); err != nil {
+		return nil, fmt.Errorf("Failed to connect heartbeat control socket: %v", err)
+	}
 	return &Server{
-		handlers:  handlers,
-		ctx:       serverCtx,
-		cancelCtx: cancelCtx,
-		shell:     shell,
-		control:   control,
-		stdin:     stdin,
-		iopub:     iopub,
-		hb:        hb,
-		connInfo:  cinfo,
-		execQueue: execQueue,
+		handlers:    handlers,
+		ctx:         serverCtx,
+		cancelCtx:   cancelCtx,
+		zmqCtx:      ctx,
+		shell:       shell,
+		control:     control,
+		stdin:       stdin,
+		iopub:       iopub,
+		hb:          hb,
+		hbControl:   hbControl,
+		hbTerminate: hbTerminate,
+		connInfo:    cinfo,
+		execQueue:   execQueue,
 	}, nil
 }
 
@@ -153,6 +211,9 @@ func (s *Server) monitorSigint() {
 		for range ch {
 			log.Info("Received SIGINT. Cancelling an ongoing execute_request")
 			s.execQueue.cancelCurrent()
+			if handler, ok := s.handlers.(InterruptHandler); ok {
+				handler.HandleInterrupt()
+			}
 		}
 	}()
 }
@@ -176,11 +237,15 @@ func isEINTR(err error) bool {
 	return ok && errno == syscall.EINTR
 }
 
-// Loop starts the server main loop
-func (s *Server) Loop() {
+// Loop starts the server main loop. It returns when the kernel was shut down,
+// either by a shutdown_request or a termination signal.
+// The returned error is not nil if the sockets could not be closed cleanly.
+func (s *Server) Loop() error {
+	hbDone := make(chan struct{})
 	go func() {
+		defer close(hbDone)
 		log.Info("Forwarding heartbeat requests")
-		if err := zmq.Proxy(s.hb, s.hb, nil); err != nil {
+		if err := zmq.ProxySteerable(s.hb, s.hb, nil, s.hbControl); err != nil {
 			log.Fatalf("Failed to echo heartbeat request: %v", err)
 		}
 		log.Info("Quitting goroutine for heartbeat requests")
@@ -203,6 +268,9 @@ func (s *Server) Loop() {
 		sockDone <- struct{}{}
 	}()
 	<-execDone
+	if handler, ok := s.handlers.(InterruptHandler); ok {
+		handler.HandleInterrupt()
+	}
 
 	if err := s.shell.notifyLoopEnd(); err != nil {
 		log.Errorf("Failed to notify the loop end to shell socket: %v", err)
@@ -214,15 +282,53 @@ func (s *Server) Loop() {
 	<-sockDone
 	<-sockDone
 
-	if err := s.iopub.close(); err != nil {
-		log.Errorf("Failed to close iopub socket: %v", err)
+	if _, err := s.hbTerminate.Send("TERMINATE", 0); err != nil {
+		log.Errorf("Failed to stop heartbeat: %v", err)
+	} else {
+		<-hbDone
 	}
-	if err := s.shell.close(); err != nil {
-		log.Errorf("Failed to close shell socket: %v", err)
+
+	return s.close()
+}
+
+// close closes all sockets and terminates the ZMQ context.
+// Messages which were not sent yet are delivered for up to socketLinger.
+func (s *Server) close() error {
+	var closeErr error
+	closeNamed := func(name string, close func() error) {
+		if err := close(); err != nil {
+			log.Errorf("Failed to close %s socket: %v", name, err)
+			if closeErr == nil {
+				closeErr = fmt.Errorf("failed to close %s socket: %v", name, err)
+			}
+		}
 	}
-	if err := s.control.close(); err != nil {
-		log.Errorf("Failed to close control socket: %v", err)
+
+	closeNamed("iopub", s.iopub.close)
+	closeNamed("shell", s.shell.close)
+	closeNamed("control", s.control.close)
+	closeNamed("stdin", s.stdin.close)
+	for _, sock := range []struct {
+		name   string
+		socket *zmq.Socket
+	}{
+		{"heartbeat", s.hb},
+		{"heartbeat control", s.hbControl},
+		{"heartbeat stop", s.hbTerminate},
+	} {
+		socket := sock.socket
+		closeNamed(sock.name, func() error {
+			return closeSocket(socket, 0)
+		})
 	}
 
-	// TODO: Support stdin.
+	if err := s.zmqCtx.Term(); err != nil {
+		log.Errorf("Failed to terminate ZMQ context: %v", err)
+		if closeErr == nil {
+			closeErr = fmt.Errorf("failed to terminate ZMQ context: %v", err)
+		}
+	}
+	s.connInfo.removeIPCFiles()
+	log.Info("Kernel shut down")
+	return closeErr
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	zmq "github.com/pebbe/zmq4"
 )
@@ -23,7 +24,7 @@ type contextAndCancel struct {
 type iopubSocket struct {
 	socket    *zmq.Socket
 	mutex     *sync.Mutex
-	hmacKey   []byte
+	signer    *signer
 	serverCtx context.Context
 	ongoing   map[*contextAndCancel]bool
 }
@@ -39,14 +40,14 @@ func newIOPubSocket(serverCtx context.Context, zmqCtx *zmq.Context, cinfo *conne
 	return &iopubSocket{
 		socket:    iopub,
 		mutex:     &sync.Mutex{},
-		hmacKey:   []byte(cinfo.Key),
+		signer:    cinfo.signer,
 		serverCtx: serverCtx,
 		ongoing:   make(map[*contextAndCancel]bool),
 	}, nil
 }
 
 func (s *iopubSocket) close() error {
-	return s.socket.Close()
+	return closeSocket(s.socket, socketLinger)
 }
 
 func (s *iopubSocket) addOngoingContext() *contextAndCancel {
@@ -91,7 +92,7 @@ func (s *iopubSocket) WithOngoingContext(f func(ctx context.Context) error, pare
 func (s *iopubSocket) sendMessage(msg *message) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	return msg.Send(s.socket, s.hmacKey)
+	return msg.Send(s.socket, s.signer)
 }
 
 func (s *iopubSocket) publishStatus(status string, parent *message) error {
@@ -161,9 +162,67 @@ func (s *iopubSocket) sendDisplayData(data *DisplayData, parent *message, update
 	}
 }
 
+// publish sends a message with the given content on the iopub socket.
+func (s *iopubSocket) publish(msgType string, content interface{}, parent *message) {
+	var msg message
+	msg.Identity = [][]byte{[]byte(msgType)}
+	msg.Header.MsgType = msgType
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.MsgID = genMsgID()
+	msg.ParentHeader = parent.Header
+	msg.Content = content
+	if err := s.sendMessage(&msg); err != nil {
+		log.Errorf("Failed to send %s: %v", msgType, err)
+	}
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#code-inputs
+func (s *iopubSocket) sendExecuteInput(code string, count int, parent *message) {
+	s.publish("execute_input", &struct {
+		Code           string `json:"code"`
+		ExecutionCount int    `json:"execution_count"`
+	}{
+		Code:           code,
+		ExecutionCount: count,
+	}, parent)
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#id6
+func (s *iopubSocket) sendExecuteResult(data *DisplayData, count int, parent *message) {
+	metadata := data.Metadata
+	if metadata == nil {
+		metadata = emptyMetadata
+	}
+	s.publish("execute_result", &struct {
+		ExecutionCount int                    `json:"execution_count"`
+		Data           map[string]interface{} `json:"data"`
+		Metadata       map[string]interface{} `json:"metadata"`
+		Transient      map[string]interface{} `json:"transient,omitempty"`
+	}{
+		ExecutionCount: count,
+		Data:           data.Data,
+		Metadata:       metadata,
+		Transient:      data.Transient,
+	}, parent)
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-errors
+func (s *iopubSocket) sendError(ename, evalue string, traceback []string, parent *message) {
+	s.publish("error", &struct {
+		Ename     string   `json:"ename"`
+		Evalue    string   `json:"evalue"`
+		Traceback []string `json:"traceback"`
+	}{
+		Ename:     ename,
+		Evalue:    evalue,
+		Traceback: traceback,
+	}, parent)
+}
+
 type shellSocket struct {
 	name          string
-	hmacKey       []byte
+	signer        *signer
 	socket        *zmq.Socket
 	resultPush    *zmq.Socket
 	resultPushMux sync.Mutex
@@ -211,7 +270,7 @@ func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string,
 	}
 	return &shellSocket{
 		name:       name,
-		hmacKey:    []byte(cinfo.Key),
+		signer:     cinfo.signer,
 		socket:     sock,
 		resultPush: resultPush,
 		resultPull: resultPull,
@@ -224,24 +283,32 @@ func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string,
 }
 
 func (s *shellSocket) close() (err error) {
-	if cerr := s.socket.Close(); cerr != nil {
+	if cerr := closeSocket(s.socket, socketLinger); cerr != nil {
 		err = cerr
 	}
-	if cerr := s.resultPush.Close(); cerr != nil {
+	if cerr := closeSocket(s.resultPush, 0); cerr != nil {
 		err = cerr
 	}
-	if cerr := s.resultPull.Close(); cerr != nil {
+	if cerr := closeSocket(s.resultPull, 0); cerr != nil {
 		err = cerr
 	}
 	return
 }
 
+// closeSocket closes a socket, keeping messages which were not sent yet for the linger time.
+func closeSocket(socket *zmq.Socket, linger time.Duration) error {
+	if err := socket.SetLinger(linger); err != nil {
+		return err
+	}
+	return socket.Close()
+}
+
 // pushResult sends a message to shellSocket so that it will be sent to the client.
 // This method is goroutine-safe.
 func (s *shellSocket) pushResult(msg *message) error {
 	s.resultPushMux.Lock()
 	defer s.resultPushMux.Unlock()
-	return msg.Send(s.resultPush, s.hmacKey)
+	return msg.Send(s.resultPush, s.signer)
 }
 
 // notifyLoopEnd notifies the end of the loop to the goroutine in loop().
@@ -304,7 +371,7 @@ func (s *shellSocket) sendKernelInfo(req *message) error {
 		// https://github.com/jupyter/notebook/blob/master/notebook/services/kernels/handlers.py#L174
 		res.Header.MsgType = "kernel_info_reply"
 		res.Content = &info
-		return res.Send(s.socket, s.hmacKey)
+		return res.Send(s.socket, s.signer)
 	}, req)
 }
 
@@ -314,7 +381,7 @@ func (s *shellSocket) handleMessages() error {
 		return fmt.Errorf("Failed to receive data from %s: %v", s.name, err)
 	}
 	var msg message
-	err = msg.Unmarshal(msgs, s.hmacKey)
+	err = msg.Unmarshal(msgs, s.signer)
 	if err != nil {
 		return fmt.Errorf("Failed to unmarshal messages from %s: %v", s.name, err)
 	}
@@ -325,11 +392,51 @@ func (s *shellSocket) handleMessages() error {
 			log.Errorf("Failed to handle kernel_info_request: %v", err)
 		}
 	case "shutdown_request":
-		log.Info("received shutdown_request.")
+		req := msg.Content.(*shutdownRequest)
+		log.Infof("received shutdown_request (restart: %v).", req.Restart)
+		reply := &shutdownReply{
+			Status:  "ok",
+			Restart: req.Restart,
+		}
+		res := newMessageWithParent(&msg)
+		res.Header.MsgType = "shutdown_reply"
+		res.Content = reply
+		if err := res.Send(s.socket, s.signer); err != nil {
+			log.Errorf("Failed to send shutdown_reply: %v", err)
+		}
+		// The reply is also broadcast, so that other clients know that the kernel is going away.
+		s.iopub.publish("shutdown_reply", reply, &msg)
 		s.cancelCtx()
-		// TODO: Send shutdown_reply
 	case "execute_request":
 		s.execQueue.push(&msg, s)
+	case "comm_open", "comm_msg", "comm_close":
+		s.execQueue.push(&msg, s)
+	case "comm_info_request":
+		res := newMessageWithParent(&msg)
+		res.Header.MsgType = "comm_info_reply"
+		res.Content = s.execQueue.comms.info(msg.Content.(*commInfoRequest))
+		if err := res.Send(s.socket, s.signer); err != nil {
+			log.Errorf("Failed to send comm_info_reply: %v", err)
+		}
+	case "interrupt_request":
+		// Used if interrupt_mode is "message" in the kernelspec.
+		// http://jupyter-client.readthedocs.io/en/latest/messaging.html#kernel-interrupt
+		if !s.execQueue.cancelCurrent() {
+			log.Info("Received interrupt_request, but no execute_request is running.")
+		}
+		if handler, ok := s.handlers.(InterruptHandler); ok {
+			handler.HandleInterrupt()
+		}
+		res := newMessageWithParent(&msg)
+		res.Header.MsgType = "interrupt_reply"
+		res.Content = &struct {
+			Status string `json:"status"`
+		}{
+			Status: "ok",
+		}
+		if err := res.Send(s.socket, s.signer); err != nil {
+			log.Errorf("Failed to send interrupt_reply: %v", err)
+		}
 	case "complete_request":
 		go func() {
 			reply := s.handlers.HandleComplete(msg.Content.(*CompleteRequest))
@@ -373,6 +480,20 @@ func (s *shellSocket) handleMessages() error {
 			res.Content = reply
 			s.pushResult(res)
 		}()
+	case "history_request":
+		go func() {
+			reply := s.handlers.HandleHistory(msg.Content.(*HistoryRequest))
+			if reply == nil {
+				reply = &HistoryReply{Status: "ok"}
+			}
+			if reply.History == nil {
+				reply.History = make([]HistoryEntry, 0)
+			}
+			res := newMessageWithParent(&msg)
+			res.Header.MsgType = "history_reply"
+			res.Content = reply
+			s.pushResult(res)
+		}()
 	default:
 		log.Warningf("Unsupported MsgType in %s: %q", s.name, typ)
 	}
@@ -391,8 +512,8 @@ func (s *shellSocket) handleResultPull() error {
 	// For some reasons, execute_reply is not handled correctly
 	// unless we unmarshal and marshal msgs rather than just forwarding them.
 	var msg message
-	if err := msg.Unmarshal(msgs, s.hmacKey); err != nil {
+	if err := msg.Unmarshal(msgs, s.signer); err != nil {
 		return err
 	}
-	return msg.Send(s.socket, s.hmacKey)
+	return msg.Send(s.socket, s.signer)
 }
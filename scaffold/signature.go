@@ -0,0 +1,107 @@
+package scaffold
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// defaultSignatureScheme is used if the connection file contains a key, but no signature scheme.
+const defaultSignatureScheme = "hmac-sha256"
+
+// signatureHashes contains the hash functions of the supported signature schemes "hmac-<name>".
+// The names are the ones used by Python's hashlib.
+var signatureHashes = map[string]func() hash.Hash{
+	"md5":        md5.New,
+	"sha1":       sha1.New,
+	"sha224":     sha256.New224,
+	"sha256":     sha256.New,
+	"sha384":     sha512.New384,
+	"sha512":     sha512.New,
+	"sha512_224": sha512.New512_224,
+	"sha512_256": sha512.New512_256,
+}
+
+// A signer signs and validates messages using the signature scheme of the connection.
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#the-wire-protocol
+type signer struct {
+	hash func() hash.Hash
+	// key is empty if messages are not signed.
+	key []byte
+}
+
+// newSigner returns a signer for the signature scheme. If the key is empty, messages are not signed.
+func newSigner(scheme, key string) (*signer, error) {
+	if key == "" {
+		return &signer{}, nil
+	}
+	if scheme == "" {
+		scheme = defaultSignatureScheme
+	}
+
+	name := strings.TrimPrefix(scheme, "hmac-")
+	h, ok := signatureHashes[name]
+	if !ok || name == scheme {
+		return nil, fmt.Errorf("Unsupported signature scheme %q (supported: %s)", scheme, strings.Join(supportedSignatureSchemes(), ", "))
+	}
+	return &signer{
+		hash: h,
+		key:  []byte(key),
+	}, nil
+}
+
+func supportedSignatureSchemes() []string {
+	schemes := make([]string, 0, len(signatureHashes))
+	for name := range signatureHashes {
+		schemes = append(schemes, "hmac-"+name)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// sign returns the hex encoded signature of the header, parent header, metadata and content of a message.
+// It is empty if messages are not signed.
+func (s *signer) sign(bodies [][]byte) []byte {
+	if len(s.key) == 0 {
+		return []byte{}
+	}
+
+	mac := hmac.New(s.hash, s.key)
+	for _, body := range bodies {
+		mac.Write(body)
+	}
+	sig := mac.Sum(nil)
+	hexSig := make([]byte, hex.EncodedLen(len(sig)))
+	hex.Encode(hexSig, sig)
+	return hexSig
+}
+
+// validate checks the hex encoded signature of a message. Signatures are not checked if messages are not signed.
+func (s *signer) validate(hexSig []byte, bodies [][]byte) error {
+	if len(s.key) == 0 {
+		return nil
+	}
+
+	mac := hmac.New(s.hash, s.key)
+	for _, body := range bodies {
+		mac.Write(body)
+	}
+	// Decode the hex signature
+	sig := make([]byte, hex.DecodedLen(len(hexSig)))
+	if _, err := hex.Decode(sig, hexSig); err != nil {
+		return fmt.Errorf("HMAC was not hex encoded: %v", err)
+	}
+	// Verify the signature
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return errors.New("HMAC was invalid")
+	}
+	return nil
+}
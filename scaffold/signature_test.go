@@ -0,0 +1,136 @@
+package scaffold
+
+import (
+	"testing"
+)
+
+var testBodies = [][]byte{
+	[]byte(`{"msg_id":"1"}`),
+	[]byte(`{}`),
+	[]byte(`{}`),
+	[]byte(`{"code":"up"}`),
+}
+
+func TestSignerSchemes(t *testing.T) {
+	for _, test := range []struct {
+		scheme    string
+		signature string
+	}{
+		{"hmac-md5", "b464c0c7aa10b3210956e651e419cffb"},
+		{"hmac-sha1", "f65ad7c9d2d62faa0261f7b4ea5130bc1993865e"},
+		{"hmac-sha224", "7059191e8e228328042485015dad538d89e3e7bea7fa56e71b8a1ee6"},
+		{"hmac-sha256", "812d17012cf9f13d6968c8c109b887793b612185d615f19ce7f28f76df8b6a36"},
+		{"", "812d17012cf9f13d6968c8c109b887793b612185d615f19ce7f28f76df8b6a36"},
+		{"hmac-sha384", "46e5da30417b9b4b62841aea351d57f8c5fd96d1d9c346d267065e062b09ded774181e4d3d9f9a6b689212c3acc1c443"},
+		{"hmac-sha512", "1753327bf5e39bd5867837245e66535f982a9026cc0d44e01f488b9975703d74e5cd701a57c745e6fe9fc0e5e301f51c10c918bc2c612609d5ed186673e775b3"},
+		{"hmac-sha512_224", "ebb5aff1a426a2292762b2072c7c018dc07bde041843176c1e3e974a"},
+		{"hmac-sha512_256", "064f11e0452ba36aebc04c22c9c58d7fde1cc82bf66b222459d91b18b0be8940"},
+	} {
+		test := test
+		t.Run(test.scheme, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := newSigner(test.scheme, "secret")
+			if err != nil {
+				t.Fatalf("got error: %s", err)
+			}
+
+			signature := s.sign(testBodies)
+			if string(signature) != test.signature {
+				t.Errorf("got signature %s, wanted %s", signature, test.signature)
+			}
+			if err := s.validate(signature, testBodies); err != nil {
+				t.Errorf("got error validating own signature: %s", err)
+			}
+		})
+	}
+}
+
+func TestSignerValidate(t *testing.T) {
+	s, err := newSigner("hmac-sha256", "secret")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	valid := s.sign(testBodies)
+
+	tampered := [][]byte{testBodies[0], testBodies[1], testBodies[2], []byte(`{"code":"down"}`)}
+	otherKey, err := newSigner("hmac-sha256", "other")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	for _, test := range []struct {
+		desc      string
+		signer    *signer
+		signature []byte
+		bodies    [][]byte
+		err       bool
+	}{
+		{
+			desc:      "valid",
+			signer:    s,
+			signature: valid,
+			bodies:    testBodies,
+		},
+		{
+			desc:      "tampered body",
+			signer:    s,
+			signature: valid,
+			bodies:    tampered,
+			err:       true,
+		},
+		{
+			desc:      "other key",
+			signer:    otherKey,
+			signature: valid,
+			bodies:    testBodies,
+			err:       true,
+		},
+		{
+			desc:      "invalid hex",
+			signer:    s,
+			signature: []byte("not hex"),
+			bodies:    testBodies,
+			err:       true,
+		},
+		{
+			desc:      "empty signature",
+			signer:    s,
+			signature: []byte{},
+			bodies:    testBodies,
+			err:       true,
+		},
+	} {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.signer.validate(test.signature, test.bodies)
+			if (err != nil) != test.err {
+				t.Errorf("got error %v, wanted error %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSignerWithoutKey(t *testing.T) {
+	s, err := newSigner("hmac-unknown", "")
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if signature := s.sign(testBodies); len(signature) != 0 {
+		t.Errorf("got signature %s, wanted none", signature)
+	}
+	if err := s.validate([]byte("anything"), testBodies); err != nil {
+		t.Errorf("got error %s, wanted signatures to be ignored", err)
+	}
+}
+
+func TestSignerUnsupportedScheme(t *testing.T) {
+	for _, scheme := range []string{"hmac-sha3_256", "sha256", "hmac-"} {
+		if _, err := newSigner(scheme, "secret"); err == nil {
+			t.Errorf("%s: got no error", scheme)
+		}
+	}
+}
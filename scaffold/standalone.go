@@ -0,0 +1,135 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DataDir returns the Jupyter data directory.
+// See https://jupyter.readthedocs.io/en/latest/use/jupyter-directories.html#data-files
+func DataDir() string {
+	if dir := os.Getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return dir
+	}
+
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Jupyter")
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "jupyter")
+	}
+
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "jupyter")
+}
+
+// RuntimeDir returns the Jupyter runtime directory, which contains the connection files.
+// See https://jupyter.readthedocs.io/en/latest/use/jupyter-directories.html#runtime-files
+func RuntimeDir() string {
+	if dir := os.Getenv("JUPYTER_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && runtime.GOOS == "linux" {
+		return filepath.Join(dir, "jupyter")
+	}
+	return filepath.Join(DataDir(), "runtime")
+}
+
+// DefaultConnectionFile returns the path of the connection file for a kernel started without a frontend.
+func DefaultConnectionFile() string {
+	return filepath.Join(RuntimeDir(), fmt.Sprintf("kernel-%d.json", os.Getpid()))
+}
+
+// defaultIP returns the IP used if none is given. For the ipc transport it is a prefix
+// for the socket files in the runtime directory.
+func defaultIP(transport string) string {
+	if transport == "ipc" {
+		return filepath.Join(RuntimeDir(), fmt.Sprintf("kernel-%d-ipc", os.Getpid()))
+	}
+	return "127.0.0.1"
+}
+
+// WriteConnectionFile writes a connection file for a kernel started without a frontend.
+// It picks free ports (or socket files for the ipc transport) and generates a new key.
+// If ip is empty, a default for the transport is used.
+func WriteConnectionFile(path, transport, ip string) error {
+	if ip == "" {
+		ip = defaultIP(transport)
+	}
+	cinfo := &connectionInfo{
+		IP:              ip,
+		Transport:       transport,
+		SignatureScheme: defaultSignatureScheme,
+		Key:             genMsgID(),
+	}
+
+	var ports []int
+	var err error
+	switch transport {
+	case "tcp":
+		ports, err = freeTCPPorts(ip, 5)
+	case "ipc":
+		if err := os.MkdirAll(filepath.Dir(ip), 0700); err != nil {
+			return fmt.Errorf("Failed to create directory for socket files: %v", err)
+		}
+		ports, err = freeIPCPorts(ip, 5)
+	default:
+		err = fmt.Errorf("Unsupported transport %q (supported: tcp, ipc)", transport)
+	}
+	if err != nil {
+		return err
+	}
+	cinfo.ShellPort, cinfo.IOPubPort, cinfo.StdinPort, cinfo.ControlPort, cinfo.HBPort = ports[0], ports[1], ports[2], ports[3], ports[4]
+
+	b, err := json.MarshalIndent(cinfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("Failed to create directory for %s: %v", path, err)
+	}
+	// The connection file contains the key, so only the user may read it.
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// freeTCPPorts returns n ports which are not in use. All ports are kept open until the last one is found,
+// so that no port is returned twice.
+func freeTCPPorts(ip string, n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to find a free port: %v", err)
+		}
+		defer listener.Close()
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// freeIPCPorts returns the first n numbers for which no socket file "ip-port" exists.
+func freeIPCPorts(ip string, n int) ([]int, error) {
+	var ports []int
+	for port := 1; len(ports) < n; port++ {
+		_, err := os.Stat(fmt.Sprintf("%s-%d", ip, port))
+		switch {
+		case os.IsNotExist(err):
+			ports = append(ports, port)
+		case err != nil:
+			return nil, fmt.Errorf("Failed to find a free socket file: %v", err)
+		}
+	}
+	return ports, nil
+}
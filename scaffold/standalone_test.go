@@ -0,0 +1,55 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteConnectionFileIPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipromnb-runtime")
+	if err != nil {
+		t.Fatalf("can not create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	runtimeDir := filepath.Join(dir, "runtime")
+	os.Setenv("JUPYTER_RUNTIME_DIR", runtimeDir)
+	defer os.Unsetenv("JUPYTER_RUNTIME_DIR")
+
+	path := DefaultConnectionFile()
+	if err := WriteConnectionFile(path, "ipc", ""); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("can not read connection file: %s", err)
+	}
+	var cinfo connectionInfo
+	if err := json.Unmarshal(data, &cinfo); err != nil {
+		t.Fatalf("can not parse connection file: %s", err)
+	}
+
+	prefix := filepath.Join(runtimeDir, fmt.Sprintf("kernel-%d-ipc", os.Getpid()))
+	if cinfo.IP != prefix {
+		t.Errorf("got socket prefix %q, wanted %q", cinfo.IP, prefix)
+	}
+	if addr := cinfo.getAddr(cinfo.ShellPort); !strings.HasPrefix(addr, "ipc://"+prefix+"-") {
+		t.Errorf("got shell address %q", addr)
+	}
+
+	// Socket files which are left behind are removed.
+	socket := fmt.Sprintf("%s-%d", cinfo.IP, cinfo.HBPort)
+	if err := ioutil.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatalf("can not create socket file: %s", err)
+	}
+	cinfo.removeIPCFiles()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket file %s was not removed: %v", socket, err)
+	}
+}
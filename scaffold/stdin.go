@@ -0,0 +1,126 @@
+package scaffold
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// stdinPollInterval is the interval in which cancellation is checked while waiting for input_reply.
+const stdinPollInterval = 100 * time.Millisecond
+
+// ErrStdinNotAllowed is returned by InputFunc if the frontend does not support input requests.
+var ErrStdinNotAllowed = errors.New("the frontend does not support input requests")
+
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-channel
+type inputRequest struct {
+	Prompt   string `json:"prompt"`
+	Password bool   `json:"password"`
+}
+
+type inputReply struct {
+	Value string `json:"value"`
+}
+
+// stdinSocket requests input from the frontends.
+// It is only used by the goroutine executing execute_requests, so it needs no locking.
+type stdinSocket struct {
+	socket *zmq.Socket
+	signer *signer
+}
+
+func newStdinSocket(zmqCtx *zmq.Context, cinfo *connectionInfo) (*stdinSocket, error) {
+	stdin, err := zmqCtx.NewSocket(zmq.ROUTER)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
+	}
+	if err := stdin.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
+		return nil, fmt.Errorf("Failed to bind stdin socket: %v", err)
+	}
+	return &stdinSocket{
+		socket: stdin,
+		signer: cinfo.signer,
+	}, nil
+}
+
+func (s *stdinSocket) close() error {
+	return closeSocket(s.socket, 0)
+}
+
+// requestInput sends input_request to the frontend which sent parent and waits for its input_reply.
+// It returns early if ctx is cancelled, for example because the execution was interrupted.
+func (s *stdinSocket) requestInput(ctx context.Context, prompt string, password bool, parent *message) (string, error) {
+	poller := zmq.NewPoller()
+	poller.Add(s.socket, zmq.POLLIN)
+	if err := s.drain(poller); err != nil {
+		return "", err
+	}
+
+	req := newMessageWithParent(parent)
+	req.Header.MsgType = "input_request"
+	req.Content = &inputRequest{
+		Prompt:   prompt,
+		Password: password,
+	}
+	if err := req.Send(s.socket, s.signer); err != nil {
+		return "", fmt.Errorf("failed to send input_request: %v", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		polled, err := poller.Poll(stdinPollInterval)
+		if isEINTR(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("poll on stdin socket failed: %v", err)
+		}
+		if len(polled) == 0 {
+			continue
+		}
+
+		msgs, err := s.socket.RecvMessageBytes(0)
+		if err != nil {
+			return "", fmt.Errorf("failed to receive data from stdin: %v", err)
+		}
+		var msg message
+		if err := msg.Unmarshal(msgs, s.signer); err != nil {
+			log.Errorf("Failed to unmarshal message from stdin: %v", err)
+			continue
+		}
+		if msg.Header.MsgType != "input_reply" {
+			log.Warningf("Unsupported MsgType in stdin: %q", msg.Header.MsgType)
+			continue
+		}
+		if msg.ParentHeader.MsgID != req.Header.MsgID {
+			log.Warningf("Dropping input_reply to an earlier input_request %q", msg.ParentHeader.MsgID)
+			continue
+		}
+		return msg.Content.(*inputReply).Value, nil
+	}
+}
+
+// drain discards messages which arrived after an earlier input_request was given up,
+// for example because the execution was interrupted.
+func (s *stdinSocket) drain(poller *zmq.Poller) error {
+	for {
+		polled, err := poller.Poll(0)
+		if isEINTR(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("poll on stdin socket failed: %v", err)
+		}
+		if len(polled) == 0 {
+			return nil
+		}
+		if _, err := s.socket.RecvMessageBytes(0); err != nil {
+			return fmt.Errorf("failed to receive data from stdin: %v", err)
+		}
+	}
+}